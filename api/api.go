@@ -1,7 +1,21 @@
 // Package api contains type definitions and utility functions for interacting with the Aura API.
 //
+// # Client
+//
+// The easiest way to use the API from Go is a Client.
+// It encodes the requests, sets the headers and decodes the responses.
+// If the controller responds with an error, a *StatusError containing the Status is returned.
+//
+//	client := api.NewClient(controllerUrl, myKey)
+//	resp, err := client.Submit(ctx, api.SubmitRequest{...})
+//	if api.IsStatus(err, http.StatusUnauthorized) {
+//		...
+//	}
+//
 // # Usage
 //
+// To use the API without a Client or from other languages:
+//
 //  1. Create an AuraApi
 //  2. Use its methods to build an endpoint URL
 //  3. POST the specified request encoded in JSON to that endpoint.
@@ -20,16 +34,24 @@
 //	var responseData XxxResponse
 //	_ = json.NewDecoder(response.Body).Decode(&responseData)
 //
+// # Versions
+//
+// The endpoints are served under /api/v1, New builds URLs for that version.
+// Use NewVersion to build URLs for another version, e.g. to talk to an older controller.
+// Runners also send their ProtocolLevel, see RunnerRequest.
+//
 // # Authentication
 //
-// Aura has four types of keys with different scopes:
+// Aura has five types of keys with different scopes:
 //
 //   - The ADMINKEY is mainly used to create new projects and runners but it can be used in every API endpoint.
 //     There is only ever one admin key; it is printed out to the console on first startup.
 //   - A PROJECTKEY is assigned to a single project and used to submit jobs for that project.
 //     It gets generated when a project is created in the web UI.
+//   - A REGISTRATIONKEY lets new runners register themselves with the controller.
+//     It gets generated when a registration token is created in the web UI.
 //   - A RUNNERKEY is configured in a runner and secures communication between runner and controller.
-//     It gets generated when a runner is created in the web UI.
+//     It gets generated when a runner is created in the web UI or registers itself.
 //   - A JOBKEY is valid while that job is executed in a runner.
 //     It is created when a job is sent to a runner and invalidated once the runner marks the job as completed.
 //
@@ -40,22 +62,61 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"path"
 	"regexp"
+	"strings"
 )
 
+// Version of Aura that this module belongs to
+const Version = "0.5.0-dev"
+
+// Versions of the API paths
+const (
+	// The unversioned paths used before 0.5.0, e.g. /api/submit.
+	// The controller still serves them for the endpoints that existed back then: job, runner, storage and submit.
+	ApiVersion0 = 0
+
+	// The paths starting with /api/v1
+	ApiVersion1 = 1
+
+	// The version used by New
+	CurrentApiVersion = ApiVersion1
+)
+
+// ProtocolLevel is the level of the runner protocol implemented by this module.
+// It is increased whenever runners have to understand something new to run jobs correctly.
+// Runners send their level with every request to /api/v1/runner.
+//
+//   - 0: runners from before protocol levels were introduced
+//   - 1: runners restore and save caches and apply job limits
+const ProtocolLevel = 1
+
+// MinProtocolLevel is the lowest protocol level of runners the controller still serves.
+// Runners below ProtocolLevel are served in compatibility mode: they only receive jobs they can run correctly.
+const MinProtocolLevel = 0
+
 // Anything that is a slug must match this regular expression
 var SlugRegex = regexp.MustCompile(`^[0-9A-Za-z-_:\.]{1,260}$`)
 
-// AuraApi provides methods to build URLs for the various API endpoints
+// AuraApi provides methods to build URLs for the various API endpoints of one API version
 type AuraApi struct {
-	baseUrl string
+	prefix string
 }
 
-// New returns a new AuraApi configured with the scheme and host from the given URL
+// New returns a new AuraApi for the CurrentApiVersion configured with the scheme and host from the given URL
 func New(baseUrl *url.URL) *AuraApi {
-	return &AuraApi{fmt.Sprintf("%s://%s", baseUrl.Scheme, baseUrl.Host)}
+	return NewVersion(baseUrl, CurrentApiVersion)
+}
+
+// NewVersion returns a new AuraApi for the given API version configured with the scheme and host from the given URL
+func NewVersion(baseUrl *url.URL, version int) *AuraApi {
+	if version == ApiVersion0 {
+		return &AuraApi{fmt.Sprintf("%s://%s/api", baseUrl.Scheme, baseUrl.Host)}
+	}
+	return &AuraApi{fmt.Sprintf("%s://%s/api/v%d", baseUrl.Scheme, baseUrl.Host, version)}
 }
 
 // Status is returned with a 4xx or 5xx status code from any endpoint
@@ -65,14 +126,116 @@ type Status struct {
 }
 
 // =============================================================================
-// /api/job
+// /api/v1/cache
+// =============================================================================
+
+// As a job, restore a cache entry from the controller.
+//
+// Send a GET request.
+// Cache entries are shared between all jobs of a project.
+// If there is no entry for key, the most recently created entry whose key starts with one of restoreKeys is returned.
+// The restore keys are tried in order.
+// The response body is the content of the cache entry, the header "X-Aura-Cache-Key" contains its key.
+// If nothing matches, Status is returned with a 404 status code.
+//
+// Endpoint: /api/v1/cache/$jobId/$key | Auth: AURA_JOBKEY
+func (a AuraApi) CacheRestore(jobId int64, key string, restoreKeys []string) string {
+	u := fmt.Sprintf("%s/cache/%d/%s", a.prefix, jobId, key)
+	if len(restoreKeys) > 0 {
+		query := url.Values{}
+		for _, restoreKey := range restoreKeys {
+			query.Add("restore", restoreKey)
+		}
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// As a job, save a cache entry on the controller.
+//
+// The request body is the content of the cache entry, usually a gzipped tarball.
+// An existing entry with the same key is replaced.
+// The controller evicts the least recently used entries once its size limit is reached.
+//
+// Endpoint: /api/v1/cache/$jobId/$key | Auth: AURA_JOBKEY
+func (a AuraApi) CacheSave(jobId int64, key string) string {
+	return fmt.Sprintf("%s/cache/%d/%s", a.prefix, jobId, key)
+}
+
+// CacheKeyHeader is the response header containing the key of a restored cache entry
+const CacheKeyHeader = "X-Aura-Cache-Key"
+
+type CacheResponse struct {
+	// This struct has been intentionally left empty
+}
+
+// JobCache configures the cache a runner restores before and saves after running a job
+type JobCache struct {
+	// Key to save the cache entry as once the job succeeded.
+	// Must match SlugRegex.
+	Key string `json:"key"`
+
+	// Key prefixes to try in order if there is no cache entry for Key
+	RestoreKeys []string `json:"restoreKeys"`
+
+	// Paths relative to the working directory of the job to include in the cache entry
+	Paths []string `json:"paths"`
+}
+
+// Validate checks that the keys and paths of the cache configuration are usable
+func (c JobCache) Validate() error {
+	if !SlugRegex.MatchString(c.Key) {
+		return errors.New("invalid cache key")
+	}
+	for _, restoreKey := range c.RestoreKeys {
+		if !SlugRegex.MatchString(restoreKey) {
+			return errors.New("invalid cache restore key")
+		}
+	}
+	if len(c.Paths) == 0 {
+		return errors.New("missing cache paths")
+	}
+	for _, p := range c.Paths {
+		clean := path.Clean(strings.ReplaceAll(p, "\\", "/"))
+		if p == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(clean, ":") {
+			return errors.New("invalid cache path")
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// /api/v1/cancel
+// =============================================================================
+
+// Cancel a job that has not started yet.
+//
+// Jobs waiting for the cancelled job as a preceding job are cancelled as well.
+// A job that has already started can not be cancelled, Status is returned with a 409 status code.
+//
+// Endpoint: /api/v1/cancel | Auth: AURA_PROJECTKEY, AURA_ADMINKEY
+func (a AuraApi) Cancel() string {
+	return fmt.Sprintf("%s/cancel", a.prefix)
+}
+
+type CancelRequest struct {
+	// The id of the job to cancel
+	Id int64 `json:"id"`
+}
+
+type CancelResponse struct {
+	// This struct has been intentionally left empty
+}
+
+// =============================================================================
+// /api/v1/job
 // =============================================================================
 
 // As a runner, use this endpoint to mark a job as completed.
 //
-// Endpoint: /api/job | Auth: AURA_RUNNERKEY
+// Endpoint: /api/v1/job | Auth: AURA_RUNNERKEY
 func (a AuraApi) Job() string {
-	return fmt.Sprintf("%s/api/job", a.baseUrl)
+	return fmt.Sprintf("%s/job", a.prefix)
 }
 
 type JobRequest struct {
@@ -84,6 +247,10 @@ type JobRequest struct {
 
 	// Exit code of that job
 	ExitCode int64 `json:"exitCode"`
+
+	// Short explanation of how the job ended if the exit code alone is not conclusive,
+	// e.g. "killed: memory limit exceeded"
+	Message string `json:"message"`
 }
 
 type JobResponse struct {
@@ -91,26 +258,518 @@ type JobResponse struct {
 }
 
 // =============================================================================
-// /api/runner
+// /api/v1/jobs
+// =============================================================================
+
+// Get a job.
+//
+// Send a GET request.
+//
+// Endpoint: /api/v1/jobs/$jobId | Auth: none
+func (a AuraApi) JobDetails(jobId int64) string {
+	return fmt.Sprintf("%s/jobs/%d", a.prefix, jobId)
+}
+
+// Get the plain text log of a job.
+//
+// Send a GET request.
+// The response body is the log as uploaded by the runner once the job completed.
+// If there is no log (yet), Status is returned with a 404 status code.
+//
+// Endpoint: /api/v1/jobs/$jobId/log | Auth: none
+func (a AuraApi) JobLog(jobId int64) string {
+	return fmt.Sprintf("%s/jobs/%d/log", a.prefix, jobId)
+}
+
+// JobInfo describes a job, it is returned by the read endpoints
+type JobInfo struct {
+	// The id of the job
+	Id int64 `json:"id"`
+
+	// Slug of the project and the entity the job is attached to
+	Project   string `json:"project"`
+	EntityKey string `json:"entityKey"`
+	EntityVal string `json:"entityVal"`
+
+	// The name of the job
+	Name string `json:"name"`
+
+	// The status of the job, one of the JobStatus* constants
+	Status string `json:"status"`
+
+	// The command run for the job
+	Cmd string `json:"cmd"`
+
+	// The tag used to find a usable runner
+	Tag string `json:"tag"`
+
+	// Unix timestamps (in seconds).
+	// Started and Ended are 0 if the job has not started or ended yet.
+	Created       int64 `json:"created"`
+	EarliestStart int64 `json:"earliestStart"`
+	Started       int64 `json:"started"`
+	Ended         int64 `json:"ended"`
+
+	// Name of the runner the job was sent to, empty if it has not started yet
+	Runner string `json:"runner"`
+
+	// Exit code of the job, only meaningful once it succeeded or failed
+	ExitCode int64 `json:"exitCode"`
+
+	// Short explanation of how the job ended if the exit code alone is not conclusive
+	Message string `json:"message"`
+
+	// Ids of the preceding jobs that have not completed yet
+	PrecedingJobs []int64 `json:"precedingJobs"`
+}
+
+// Values of JobInfo.Status
+const (
+	JobStatusSubmitted = "submitted"
+	JobStatusCreated   = "created"
+	JobStatusStarted   = "started"
+	JobStatusCancelled = "cancelled"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Done reports whether the job will not change anymore
+func (j JobInfo) Done() bool {
+	return j.Status == JobStatusCancelled || j.Status == JobStatusSucceeded || j.Status == JobStatusFailed
+}
+
+// Explain why a job has not started yet.
+//
+// Send a GET request.
+// The same diagnostics are shown on the job page in the web UI.
+//
+// Endpoint: /api/v1/jobs/$jobId/diagnostics | Auth: none
+func (a AuraApi) JobDiagnostics(jobId int64) string {
+	return fmt.Sprintf("%s/jobs/%d/diagnostics", a.prefix, jobId)
+}
+
+// Kinds of a JobBlocker
+const (
+	// The controller has not finished processing the submission of the job
+	BlockerSubmitted = "submitted"
+	// The job waits for preceding jobs to complete
+	BlockerPrecedingJobs = "precedingJobs"
+	// The earliest start of the job is in the future
+	BlockerEarliestStart = "earliestStart"
+	// No runner that may run the job has checked in recently
+	BlockerNoRunner = "noRunner"
+	// All runners that may run the job are busy with other jobs
+	BlockerRunnersBusy = "runnersBusy"
+	// Only runners in compatibility mode, which do not get jobs with cache or limits, may run the job
+	BlockerRunnersOutdated = "runnersOutdated"
+)
+
+type JobDiagnosticsResponse struct {
+	// The id of the job
+	Id int64 `json:"id"`
+
+	// The status of the job, e.g. "created"
+	Status string `json:"status"`
+
+	// Whether the job is queued and nothing prevents the next runner checking in with its tag from picking it up
+	Ready bool `json:"ready"`
+
+	// Everything that currently prevents the job from starting.
+	// Empty if the job is ready or not queued anymore.
+	Blockers []JobBlocker `json:"blockers"`
+
+	// Position of the job among the queued jobs with the same tag, starting at 1.
+	// 0 if the job is not queued anymore.
+	QueuePosition int64 `json:"queuePosition"`
+
+	// Number of queued jobs with the same tag
+	QueueLength int64 `json:"queueLength"`
+}
+
+// JobBlocker is a single reason why a job has not started yet
+type JobBlocker struct {
+	// One of the Blocker* constants
+	Kind string `json:"kind"`
+
+	// Human readable explanation
+	Message string `json:"message"`
+
+	// Ids of the preceding jobs that have not completed yet, only set for BlockerPrecedingJobs
+	JobIds []int64 `json:"jobIds,omitempty"`
+
+	// Unix timestamp of the earliest start, only set for BlockerEarliestStart
+	Until int64 `json:"until,omitempty"`
+
+	// Names of the busy runners, only set for BlockerRunnersBusy
+	Runners []string `json:"runners,omitempty"`
+}
+
+// =============================================================================
+// /api/v1/keys
+// =============================================================================
+
+// List the keys of a project, of a runner, or the admin keys if both are empty, newest first.
+//
+// Send a GET request.
+// Revoked and expired keys are included, the keys themselves are never returned.
+//
+// Endpoint: /api/v1/keys?project=$project or ?runner=$runner | Auth: AURA_PROJECTKEY or AURA_RUNNERKEY of the owner, AURA_ADMINKEY
+func (a AuraApi) Keys(project string, runner string) string {
+	u := fmt.Sprintf("%s/keys", a.prefix)
+	if project != "" {
+		u += "?project=" + url.QueryEscape(project)
+	} else if runner != "" {
+		u += "?runner=" + url.QueryEscape(runner)
+	}
+	return u
+}
+
+// Create a new key for a project, a runner or the admin.
+//
+// The previous keys keep working for the overlap, so that they can be replaced everywhere before they stop working.
+// Without an overlap they are revoked right away.
+//
+// Endpoint: /api/v1/keys/rotate | Auth: AURA_PROJECTKEY or AURA_RUNNERKEY of the owner, AURA_ADMINKEY
+func (a AuraApi) KeysRotate() string {
+	return fmt.Sprintf("%s/keys/rotate", a.prefix)
+}
+
+// Revoke a key immediately.
+//
+// The last usable admin key can not be revoked, Status is returned with a 409 status code.
+//
+// Endpoint: /api/v1/keys/revoke | Auth: AURA_PROJECTKEY or AURA_RUNNERKEY of the owner, AURA_ADMINKEY
+func (a AuraApi) KeysRevoke() string {
+	return fmt.Sprintf("%s/keys/revoke", a.prefix)
+}
+
+type KeysResponse struct {
+	Keys []KeyInfo `json:"keys"`
+}
+
+type KeyInfo struct {
+	Id int64 `json:"id"`
+
+	// One of "admin", "project" or "runner"
+	Kind string `json:"kind"`
+
+	// Slug of the project or name of the runner, empty for admin keys
+	Owner string `json:"owner"`
+
+	// One of "active", "expiring", "expired" or "revoked"
+	State string `json:"state"`
+
+	// Unix timestamps (in seconds), 0 if the key never expires, is not revoked or was never used.
+	// LastUsed is updated at most once a minute.
+	Created  int64 `json:"created"`
+	Expires  int64 `json:"expires"`
+	Revoked  int64 `json:"revoked"`
+	LastUsed int64 `json:"lastUsed"`
+}
+
+type KeyRotateRequest struct {
+	// Slug of the project or name of the runner, rotates an admin key if both are empty
+	Project string `json:"project"`
+	Runner  string `json:"runner"`
+
+	// Seconds the previous keys keep working, 0 to revoke them right away
+	Overlap int64 `json:"overlap"`
+
+	// Seconds after which the new key expires, 0 to never expire
+	ExpiresAfter int64 `json:"expiresAfter"`
+}
+
+type KeyRotateResponse struct {
+	// The new key, it is only returned this once
+	Key string `json:"key"`
+
+	Info KeyInfo `json:"info"`
+}
+
+type KeyRevokeRequest struct {
+	// The id of the key to revoke
+	Id int64 `json:"id"`
+}
+
+type KeyRevokeResponse struct {
+	// This struct has been intentionally left empty
+}
+
+// =============================================================================
+// /api/v1/projects
+// =============================================================================
+
+// List all projects.
+//
+// Send a GET request.
+//
+// Endpoint: /api/v1/projects | Auth: none
+func (a AuraApi) Projects() string {
+	return fmt.Sprintf("%s/projects", a.prefix)
+}
+
+// Get a project with the most recent entities or collections for each of its keys.
+//
+// Send a GET request.
+//
+// Endpoint: /api/v1/projects/$project | Auth: none
+func (a AuraApi) Project(project string) string {
+	return fmt.Sprintf("%s/projects/%s", a.prefix, project)
+}
+
+// List the entities of a project with the given key, newest first.
+// If there are no entities with the key, the collections with the key are listed instead.
+//
+// Send a GET request.
+// At most 10 entities are returned, set before to EntitiesResponse.Older to get the next ones.
+//
+// Endpoint: /api/v1/projects/$project/$key | Auth: none
+func (a AuraApi) Entities(project string, key string, before int64) string {
+	u := fmt.Sprintf("%s/projects/%s/%s", a.prefix, project, key)
+	if before > 0 {
+		u += fmt.Sprintf("?before=%d", before)
+	}
+	return u
+}
+
+// Get an entity with all of its jobs, or a collection with the entities it includes.
+//
+// Send a GET request.
+// At most 10 entities of a collection are returned, set before to EntityResponse.Older to get the next ones.
+//
+// Endpoint: /api/v1/projects/$project/$key/$val | Auth: none
+func (a AuraApi) Entity(project string, key string, val string, before int64) string {
+	u := fmt.Sprintf("%s/projects/%s/%s/%s", a.prefix, project, key, val)
+	if before > 0 {
+		u += fmt.Sprintf("?before=%d", before)
+	}
+	return u
+}
+
+type ProjectsResponse struct {
+	Projects []ProjectInfo `json:"projects"`
+}
+
+type ProjectInfo struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type ProjectResponse struct {
+	Project ProjectInfo `json:"project"`
+
+	// All entity and collection keys of the project, sorted by key
+	Keys []ProjectKey `json:"keys"`
+}
+
+type ProjectKey struct {
+	Key string `json:"key"`
+
+	// Whether the values of the key are collections rather than entities
+	Collections bool `json:"collections"`
+
+	// The most recent entities or collections with the key, newest first
+	Entities []EntityInfo `json:"entities"`
+
+	// Pass as before to Entities to get the next ones, 0 if there are no more
+	Older int64 `json:"older"`
+}
+
+type EntitiesResponse struct {
+	// Whether the values of the key are collections rather than entities
+	Collections bool `json:"collections"`
+
+	Entities []EntityInfo `json:"entities"`
+
+	// Pass as before to get the next entities, 0 if there are no more
+	Older int64 `json:"older"`
+}
+
+type EntityInfo struct {
+	Key string `json:"key"`
+	Val string `json:"val"`
+
+	// Unix timestamp (in seconds) of the creation of the entity
+	Created int64 `json:"created"`
+}
+
+type EntityResponse struct {
+	Entity EntityInfo `json:"entity"`
+
+	// Whether Entity is a collection
+	Collection bool `json:"collection"`
+
+	// All jobs of the entity, oldest first.
+	// Empty for a collection.
+	Jobs []JobInfo `json:"jobs"`
+
+	// The collections that include the entity.
+	// Empty for a collection.
+	Collections []EntityInfo `json:"collections"`
+
+	// The entities included in the collection, newest first.
+	// Empty for an entity.
+	Entities []EntityInfo `json:"entities"`
+
+	// Pass as before to get the next entities of the collection, 0 if there are no more
+	Older int64 `json:"older"`
+}
+
+// =============================================================================
+// /api/v1/queue
+// =============================================================================
+
+// List the queued jobs grouped by tag, mirroring the queue page in the web UI.
+//
+// Send a GET request.
+// Set tag to only return the jobs with that tag.
+// At most 10 jobs per tag are returned, set tag to QueueTag.Tag and before to QueueTag.Older to get the next ones of that tag.
+//
+// Endpoint: /api/v1/queue | Auth: none
+func (a AuraApi) Queue(tag string, before int64) string {
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	if before > 0 {
+		query.Set("before", fmt.Sprintf("%d", before))
+	}
+	u := fmt.Sprintf("%s/queue", a.prefix)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+type QueueResponse struct {
+	Tags []QueueTag `json:"tags"`
+}
+
+type QueueTag struct {
+	Tag string `json:"tag"`
+
+	// Number of queued jobs with the tag
+	Count int64 `json:"count"`
+
+	// Unix timestamp (in seconds) of the creation of the oldest queued job
+	Oldest int64 `json:"oldest"`
+
+	// Number of online runners with the tag
+	OnlineRunners int `json:"onlineRunners"`
+
+	// Whether no runner that may run the jobs has checked in recently
+	Starving bool `json:"starving"`
+
+	// The queued jobs, newest first
+	Jobs []JobInfo `json:"jobs"`
+
+	// Pass as before to get the next jobs, 0 if there are no more
+	Older int64 `json:"older"`
+}
+
+// =============================================================================
+// /api/v1/register
+// =============================================================================
+
+// Register a new runner with the controller.
+//
+// The registration key is exchanged for a runner key which is only returned this once.
+// If the registration key belongs to a project, the new runner only receives jobs of that project.
+// A single-use registration key becomes invalid once a runner has been registered with it.
+//
+// Endpoint: /api/v1/register | Auth: AURA_REGISTRATIONKEY, AURA_ADMINKEY
+func (a AuraApi) Register() string {
+	return fmt.Sprintf("%s/register", a.prefix)
+}
+
+type RegisterRequest struct {
+	// The name of the new runner.
+	// Must match SlugRegex and must not be used by another runner.
+	Name string `json:"name"`
+}
+
+type RegisterResponse struct {
+	// The key the new runner authenticates with from now on
+	RunnerKey string `json:"runnerKey"`
+}
+
+// =============================================================================
+// /api/v1/rerun
+// =============================================================================
+
+// Submit a job again.
+//
+// The new job is attached to the same entity and runs the same command with the same environment, tag, cache and limits.
+// It does not wait for the preceding jobs of the original job.
+//
+// Endpoint: /api/v1/rerun | Auth: AURA_PROJECTKEY, AURA_ADMINKEY
+func (a AuraApi) Rerun() string {
+	return fmt.Sprintf("%s/rerun", a.prefix)
+}
+
+type RerunRequest struct {
+	// The id of the job to run again
+	Id int64 `json:"id"`
+}
+
+type RerunResponse struct {
+	// The id of the newly created job
+	Id int64 `json:"id"`
+}
+
+// =============================================================================
+// /api/v1/runner
 // =============================================================================
 
 // As a runner, use this endpoint to request new jobs from the controller or simply check in.
 //
-// Endpoint: /api/runner | Auth: AURA_RUNNERKEY
+// Runners with a protocol level below MinProtocolLevel or above ProtocolLevel of the controller are rejected.
+// Runners below ProtocolLevel are served in compatibility mode, they do not receive jobs with a cache or limits.
+//
+// Endpoint: /api/v1/runner | Auth: AURA_RUNNERKEY
 func (a AuraApi) Runner() string {
-	return fmt.Sprintf("%s/api/runner", a.baseUrl)
+	return fmt.Sprintf("%s/runner", a.prefix)
 }
 
 type RunnerRequest struct {
 	// The name of the runner sending the request
 	Name string `json:"name"`
 
+	// Version of the runner, usually Version of the api module it was built with
+	Version string `json:"version"`
+
+	// The protocol level the runner implements, usually ProtocolLevel of the api module it was built with.
+	// If the controller does not support the level, Status is returned with a 426 status code.
+	Protocol int `json:"protocol"`
+
 	// A list of tags this runner requests jobs for, sorted by priority
 	Tags []string `json:"tags"`
 
 	// The number of jobs to return.
 	// Set to 0 to check in to the controller but not request any new jobs.
 	Limit int `json:"limit"`
+
+	// Information about the system the runner is running on
+	Info *RunnerInfo `json:"info"`
+}
+
+// RunnerInfo describes the system a runner is running on.
+// Numbers are 0 if the runner is unable to determine them.
+type RunnerInfo struct {
+	// Operating system and architecture in the format of GOOS and GOARCH
+	Os   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Number of logical CPUs
+	Cpus int `json:"cpus"`
+
+	// Total memory in bytes
+	Memory int64 `json:"memory"`
+
+	// Free disk space in bytes in the working directory of the runner
+	FreeDisk int64 `json:"freeDisk"`
+
+	Hostname string `json:"hostname"`
 }
 
 type RunnerResponse struct {
@@ -118,7 +777,9 @@ type RunnerResponse struct {
 }
 
 type RunnerResponseJob struct {
-	Id        int64  `json:"id"`
+	Id int64 `json:"id"`
+
+	// Slug of the project, the runner passes it on to the job as AURA_PROJECT
 	Project   string `json:"project"`
 	EntityKey string `json:"entityKey"`
 	EntityVal string `json:"entityVal"`
@@ -127,35 +788,87 @@ type RunnerResponseJob struct {
 	Cmd       string `json:"cmd"`
 	Env       string `json:"env"`
 	Tag       string `json:"tag"`
+
+	// The cache to restore before and save after running the job, if any
+	Cache *JobCache `json:"cache"`
+
+	// The resources the job may use, if restricted
+	Limits *JobLimits `json:"limits"`
+}
+
+// JobLimits restricts the resources a job may use on a runner.
+// A value of 0 means that resource is not restricted by the job.
+// Runners may enforce stricter limits of their own.
+type JobLimits struct {
+	// Number of CPUs, fractions are allowed
+	Cpus float64 `json:"cpus"`
+
+	// Memory in bytes
+	Memory int64 `json:"memory"`
+
+	// Number of processes and threads
+	Processes int64 `json:"processes"`
+
+	// Disk space in bytes the working directory of the job may use
+	Disk int64 `json:"disk"`
+}
+
+// Validate checks that no limit is negative
+func (l JobLimits) Validate() error {
+	if l.Cpus < 0 || l.Memory < 0 || l.Processes < 0 || l.Disk < 0 {
+		return errors.New("invalid limits")
+	}
+	return nil
 }
 
 // =============================================================================
-// /api/storage
+// /api/v1/storage
 // =============================================================================
 
 // As a job or as a runner, upload artifacts to the controller.
 //
 // The request body is the content of the file to upload.
-// Path may only be "log".
+// Path may only be "log" or "log.jsonl".
+// The file "log" contains the plain text output of the job.
+// The file "log.jsonl" contains the same output as structured log, one LogLine encoded in JSON per line.
 //
-// Endpoint: /api/storage/$jobId/$path | Auth: AURA_RUNNERKEY, AURA_JOBKEY
+// Endpoint: /api/v1/storage/$jobId/$path | Auth: AURA_RUNNERKEY, AURA_JOBKEY
 func (a AuraApi) Storage(jobId int64, path string) string {
-	return fmt.Sprintf("%s/api/storage/%d/%s", a.baseUrl, jobId, path)
+	return fmt.Sprintf("%s/storage/%d/%s", a.prefix, jobId, path)
 }
 
 type StorageResponse struct {
 	// This struct has been intentionally left empty
 }
 
+// Streams of a LogLine
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamAura   = "aura" // messages of the runner itself, e.g. about the cache
+)
+
+// LogLine is a single line of output of a job
+type LogLine struct {
+	// Unix timestamp (in milliseconds) of when the line was written
+	Time int64 `json:"t"`
+
+	// Stream the line was written to, one of StreamStdout, StreamStderr or StreamAura
+	Stream string `json:"s"`
+
+	// Content of the line without the line break
+	Line string `json:"l"`
+}
+
 // =============================================================================
-// /api/submit
+// /api/v1/submit
 // =============================================================================
 
 // Submit a new job to the controller.
 //
-// Endpoint: /api/submit | Auth: AURA_PROJECTKEY, AURA_JOBKEY
+// Endpoint: /api/v1/submit | Auth: AURA_PROJECTKEY, AURA_JOBKEY
 func (a AuraApi) Submit() string {
-	return fmt.Sprintf("%s/api/submit", a.baseUrl)
+	return fmt.Sprintf("%s/submit", a.prefix)
 }
 
 type SubmitRequest struct {
@@ -199,9 +912,85 @@ type SubmitRequest struct {
 	// Unix timestamp (in seconds) of the earliest possibly start for this job.
 	// Leave out (nil) to not restrict.
 	EarliestStart *int64 `json:"earliestStart"`
+
+	// The cache the runner restores before and saves after running the job.
+	// Leave out (nil) to not use a cache.
+	Cache *JobCache `json:"cache"`
+
+	// The resources the job may use on the runner.
+	// Leave out (nil) to only apply the limits of the runner.
+	Limits *JobLimits `json:"limits"`
 }
 
 type SubmitResponse struct {
-	// The id of the newly created job
+	// The id of the newly created job, 0 if no job was created
 	Id int64 `json:"id"`
+
+	// The ids of all created jobs.
+	// Integrations may create several jobs or none for a request, e.g. for a ping event.
+	Ids []int64 `json:"ids"`
+}
+
+// =============================================================================
+// Outbound webhooks
+// =============================================================================
+
+// The controller POSTs a WebhookPayload to the URL of every webhook of a project that is subscribed to the event.
+// The request carries these headers:
+//
+//   - X-Aura-Event: the event, one of the WebhookEvent* constants
+//   - X-Aura-Signature-256: "sha256=" followed by the hex encoded HMAC-SHA256 of the body, keyed with the secret of the webhook
+const (
+	WebhookEventHeader     = "X-Aura-Event"
+	WebhookSignatureHeader = "X-Aura-Signature-256"
+)
+
+// Events a webhook can subscribe to.
+// Job events are sent when a job reaches the status, entity events when the aggregated state of an entity changes.
+const (
+	WebhookEventJobCreated    = "job.created"
+	WebhookEventJobStarted    = "job.started"
+	WebhookEventJobSucceeded  = "job.succeeded"
+	WebhookEventJobFailed     = "job.failed"
+	WebhookEventJobCancelled  = "job.cancelled"
+	WebhookEventEntityPending = "entity.pending"
+	WebhookEventEntitySuccess = "entity.success"
+	WebhookEventEntityFailure = "entity.failure"
+)
+
+// WebhookEvents lists all events a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookEventJobCreated,
+	WebhookEventJobStarted,
+	WebhookEventJobSucceeded,
+	WebhookEventJobFailed,
+	WebhookEventJobCancelled,
+	WebhookEventEntityPending,
+	WebhookEventEntitySuccess,
+	WebhookEventEntityFailure,
+}
+
+type WebhookPayload struct {
+	// The event, one of the WebhookEvent* constants
+	Event string `json:"event"`
+
+	// Unix timestamp (in seconds) of the event
+	Timestamp int64 `json:"timestamp"`
+
+	// The project and the entity the event happened in
+	Project ProjectInfo `json:"project"`
+	Entity  EntityInfo  `json:"entity"`
+
+	// The job for job events, nil for entity events
+	Job *JobInfo `json:"job"`
+
+	// The aggregated state of the entity: "pending", "success" or "failure".
+	// Sent with every event.
+	State string `json:"state"`
+
+	// Summary of the jobs of the entity, e.g. "1 running, 2 succeeded"
+	Description string `json:"description"`
+
+	// Link to the job page for job events or to the entity page for entity events
+	Url string `json:"url"`
 }
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Client sends requests to the Aura API and decodes the responses.
+// Errors returned by the controller are returned as *StatusError.
+type Client struct {
+	// HTTPClient is used to send the requests, http.DefaultClient is used if it is nil
+	HTTPClient *http.Client
+
+	// Key is sent as bearer token with every request
+	Key string
+
+	api *AuraApi
+}
+
+// NewClient returns a new Client for the controller at baseUrl that authenticates with key
+func NewClient(baseUrl *url.URL, key string) *Client {
+	return &Client{Key: key, api: New(baseUrl)}
+}
+
+// WithKey returns a copy of the client that authenticates with another key
+func (c *Client) WithKey(key string) *Client {
+	client := *c
+	client.Key = key
+	return &client
+}
+
+// StatusError is returned by Client if the controller responds with a status code other than 200
+type StatusError struct {
+	Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("aura: %d %s", e.Code, e.Message)
+}
+
+// IsStatus reports whether err is a *StatusError with the given code
+func IsStatus(err error, code int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do sends the request and returns the response if the status code is 200
+func (c *Client) do(ctx context.Context, method string, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status Status
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		if err != nil || status.Code == 0 {
+			status = Status{Code: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return nil, &StatusError{status}
+	}
+	return resp, nil
+}
+
+// doJson sends reqData encoded in JSON and decodes the JSON response into respData
+func (c *Client) doJson(ctx context.Context, method string, url string, reqData any, respData any) error {
+	var body io.Reader
+	contentType := ""
+	if reqData != nil {
+		payload, err := json.Marshal(reqData)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+		contentType = "application/json"
+	}
+	resp, err := c.do(ctx, method, url, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(respData)
+}
+
+// CacheRestore downloads a cache entry, see AuraApi.CacheRestore.
+// Returns the content of the entry and its key, the caller has to close the content.
+// A cache miss is returned as *StatusError with code 404.
+func (c *Client) CacheRestore(ctx context.Context, jobId int64, key string, restoreKeys []string) (io.ReadCloser, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.api.CacheRestore(jobId, key, restoreKeys), "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get(CacheKeyHeader), nil
+}
+
+// CacheSave uploads a cache entry, see AuraApi.CacheSave
+func (c *Client) CacheSave(ctx context.Context, jobId int64, key string, content io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPost, c.api.CacheSave(jobId, key), "application/gzip", content)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Cancel cancels a job that has not started yet, see AuraApi.Cancel
+func (c *Client) Cancel(ctx context.Context, jobId int64) error {
+	var resp CancelResponse
+	return c.doJson(ctx, http.MethodPost, c.api.Cancel(), CancelRequest{Id: jobId}, &resp)
+}
+
+// CompleteJob marks a job as completed, see AuraApi.Job
+func (c *Client) CompleteJob(ctx context.Context, req JobRequest) (JobResponse, error) {
+	var resp JobResponse
+	err := c.doJson(ctx, http.MethodPost, c.api.Job(), req, &resp)
+	return resp, err
+}
+
+// Entities lists the entities of a project with the given key, see AuraApi.Entities
+func (c *Client) Entities(ctx context.Context, project string, key string, before int64) (EntitiesResponse, error) {
+	var resp EntitiesResponse
+	err := c.doJson(ctx, http.MethodGet, c.api.Entities(project, key, before), nil, &resp)
+	return resp, err
+}
+
+// Entity returns an entity with all of its jobs or a collection with its entities, see AuraApi.Entity
+func (c *Client) Entity(ctx context.Context, project string, key string, val string, before int64) (EntityResponse, error) {
+	var resp EntityResponse
+	err := c.doJson(ctx, http.MethodGet, c.api.Entity(project, key, val, before), nil, &resp)
+	return resp, err
+}
+
+// JobDetails returns a job, see AuraApi.JobDetails
+func (c *Client) JobDetails(ctx context.Context, jobId int64) (JobInfo, error) {
+	var resp JobInfo
+	err := c.doJson(ctx, http.MethodGet, c.api.JobDetails(jobId), nil, &resp)
+	return resp, err
+}
+
+// JobDiagnostics explains why a job has not started yet, see AuraApi.JobDiagnostics
+func (c *Client) JobDiagnostics(ctx context.Context, jobId int64) (JobDiagnosticsResponse, error) {
+	var resp JobDiagnosticsResponse
+	err := c.doJson(ctx, http.MethodGet, c.api.JobDiagnostics(jobId), nil, &resp)
+	return resp, err
+}
+
+// JobLog downloads the plain text log of a job, see AuraApi.JobLog.
+// The caller has to close the returned log.
+func (c *Client) JobLog(ctx context.Context, jobId int64) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.api.JobLog(jobId), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Project returns a project with the most recent entities for each of its keys, see AuraApi.Project
+func (c *Client) Project(ctx context.Context, project string) (ProjectResponse, error) {
+	var resp ProjectResponse
+	err := c.doJson(ctx, http.MethodGet, c.api.Project(project), nil, &resp)
+	return resp, err
+}
+
+// Projects lists all projects, see AuraApi.Projects
+func (c *Client) Projects(ctx context.Context) (ProjectsResponse, error) {
+	var resp ProjectsResponse
+	err := c.doJson(ctx, http.MethodGet, c.api.Projects(), nil, &resp)
+	return resp, err
+}
+
+// Queue lists the queued jobs grouped by tag, see AuraApi.Queue
+func (c *Client) Queue(ctx context.Context, tag string, before int64) (QueueResponse, error) {
+	var resp QueueResponse
+	err := c.doJson(ctx, http.MethodGet, c.api.Queue(tag, before), nil, &resp)
+	return resp, err
+}
+
+// Register registers a new runner, see AuraApi.Register
+func (c *Client) Register(ctx context.Context, req RegisterRequest) (RegisterResponse, error) {
+	var resp RegisterResponse
+	err := c.doJson(ctx, http.MethodPost, c.api.Register(), req, &resp)
+	return resp, err
+}
+
+// RequestJobs requests new jobs or checks in as a runner, see AuraApi.Runner
+func (c *Client) RequestJobs(ctx context.Context, req RunnerRequest) (RunnerResponse, error) {
+	var resp RunnerResponse
+	err := c.doJson(ctx, http.MethodPost, c.api.Runner(), req, &resp)
+	return resp, err
+}
+
+// Rerun submits a job again, see AuraApi.Rerun
+func (c *Client) Rerun(ctx context.Context, jobId int64) (RerunResponse, error) {
+	var resp RerunResponse
+	err := c.doJson(ctx, http.MethodPost, c.api.Rerun(), RerunRequest{Id: jobId}, &resp)
+	return resp, err
+}
+
+// Submit submits a new job, see AuraApi.Submit
+func (c *Client) Submit(ctx context.Context, req SubmitRequest) (SubmitResponse, error) {
+	var resp SubmitResponse
+	err := c.doJson(ctx, http.MethodPost, c.api.Submit(), req, &resp)
+	return resp, err
+}
+
+// UploadLog uploads the plain text log of a job, see AuraApi.Storage
+func (c *Client) UploadLog(ctx context.Context, jobId int64, content io.Reader) error {
+	return c.upload(ctx, jobId, "log", "text/plain", content)
+}
+
+// UploadStructuredLog uploads the structured log of a job, see AuraApi.Storage
+func (c *Client) UploadStructuredLog(ctx context.Context, jobId int64, content io.Reader) error {
+	return c.upload(ctx, jobId, "log.jsonl", "application/jsonl", content)
+}
+
+func (c *Client) upload(ctx context.Context, jobId int64, path string, contentType string, content io.Reader) error {
+	var resp StorageResponse
+	httpResp, err := c.do(ctx, http.MethodPost, c.api.Storage(jobId, path), contentType, content)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	return json.NewDecoder(httpResp.Body).Decode(&resp)
+}
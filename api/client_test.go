@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClientStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer AURA_PROJECTKEY_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("{\"code\":401,\"message\":\"unauthorized\"}"))
+			return
+		}
+		w.Write([]byte("{\"id\":42}"))
+	}))
+	defer server.Close()
+	serverUrl, _ := url.Parse(server.URL)
+
+	client := NewClient(serverUrl, "AURA_PROJECTKEY_test")
+	resp, err := client.Submit(context.Background(), SubmitRequest{})
+	if err != nil || resp.Id != 42 {
+		t.Fatal(resp, err)
+	}
+
+	_, err = client.WithKey("AURA_PROJECTKEY_wrong").Submit(context.Background(), SubmitRequest{})
+	if !IsStatus(err, http.StatusUnauthorized) || err.(*StatusError).Message != "unauthorized" {
+		t.Fatal(err)
+	}
+}
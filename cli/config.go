@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/unnamedtiger/aura/api"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is read from a YAML or JSON file and the AURA_* environment variables.
+// The environment variables take precedence over the file.
+type Config struct {
+	// URL of the controller, e.g. http://localhost:8420
+	Controller string `yaml:"controller"`
+
+	// Key to authenticate with, usually a PROJECTKEY
+	Key string `yaml:"key"`
+
+	// Slug of the project used if a command does not name one
+	Project string `yaml:"project"`
+
+	// Set when running inside a job, used as defaults for submitting child jobs
+	JobId     int64  `yaml:"-"`
+	EntityKey string `yaml:"-"`
+	EntityVal string `yaml:"-"`
+}
+
+// LoadConfig reads the config file and applies the environment variables.
+// A missing config file is only an error if it was named explicitly.
+func LoadConfig(configFile string) (Config, error) {
+	explicit := true
+	if configFile == "" {
+		configFile = os.Getenv("AURA_CONFIG")
+	}
+	if configFile == "" {
+		explicit = false
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			configFile = filepath.Join(dir, "aura", "config.yaml")
+		}
+	}
+
+	var cfg Config
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			if explicit || !errors.Is(err, os.ErrNotExist) {
+				return Config{}, err
+			}
+		} else {
+			err = yaml.Unmarshal(data, &cfg)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s: %w", configFile, err)
+			}
+		}
+	}
+
+	if v := os.Getenv("AURA_CONTROLLER"); v != "" {
+		cfg.Controller = v
+	}
+	// inside a job the job key is picked up automatically
+	if v := os.Getenv("AURA_KEY"); v != "" {
+		cfg.Key = v
+	} else if v := os.Getenv("AURA_JOBKEY"); v != "" {
+		cfg.Key = v
+	}
+	if v := os.Getenv("AURA_PROJECT"); v != "" {
+		cfg.Project = v
+	}
+	if v := os.Getenv("AURA_JOBID"); v != "" {
+		jobId, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid AURA_JOBID: %w", err)
+		}
+		cfg.JobId = jobId
+	}
+	cfg.EntityKey = os.Getenv("AURA_ENTITYKEY")
+	cfg.EntityVal = os.Getenv("AURA_ENTITYVAL")
+	return cfg, nil
+}
+
+// IsJobKey reports whether the configured key is the key of a running job
+func (cfg Config) IsJobKey() bool {
+	return strings.HasPrefix(cfg.Key, "AURA_JOBKEY_")
+}
+
+// Client returns a client for the configured controller
+func (cfg Config) Client() (*api.Client, error) {
+	if cfg.Controller == "" {
+		return nil, errors.New("no controller configured, set AURA_CONTROLLER or controller in the config file")
+	}
+	controllerUrl, err := url.Parse(cfg.Controller)
+	if err != nil {
+		return nil, fmt.Errorf("invalid controller: %w", err)
+	}
+	return api.NewClient(controllerUrl, cfg.Key), nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte("controller: http://aura.example\nkey: AURA_PROJECTKEY_file\nproject: colors\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected Config
+		valid    bool
+	}{
+		{"file", nil, Config{Controller: "http://aura.example", Key: "AURA_PROJECTKEY_file", Project: "colors"}, true},
+		{"overrides", map[string]string{"AURA_CONTROLLER": "http://localhost:8420", "AURA_KEY": "AURA_PROJECTKEY_env", "AURA_PROJECT": "shapes"}, Config{Controller: "http://localhost:8420", Key: "AURA_PROJECTKEY_env", Project: "shapes"}, true},
+		{"inside a job", map[string]string{"AURA_JOBKEY": "AURA_JOBKEY_job", "AURA_JOBID": "42", "AURA_ENTITYKEY": "rev", "AURA_ENTITYVAL": "abc"}, Config{Controller: "http://aura.example", Key: "AURA_JOBKEY_job", Project: "colors", JobId: 42, EntityKey: "rev", EntityVal: "abc"}, true},
+		{"key over job key", map[string]string{"AURA_KEY": "AURA_PROJECTKEY_env", "AURA_JOBKEY": "AURA_JOBKEY_job"}, Config{Controller: "http://aura.example", Key: "AURA_PROJECTKEY_env", Project: "colors"}, true},
+		{"invalid job id", map[string]string{"AURA_JOBID": "abc"}, Config{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"AURA_CONFIG", "AURA_CONTROLLER", "AURA_KEY", "AURA_JOBKEY", "AURA_PROJECT", "AURA_JOBID", "AURA_ENTITYKEY", "AURA_ENTITYVAL"} {
+				t.Setenv(name, test.env[name])
+			}
+			cfg, err := LoadConfig(file)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected error, got %+v", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, cfg)
+			}
+		})
+	}
+
+	t.Setenv("AURA_CONFIG", "")
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Error("expected error for a missing config file that was named explicitly")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+func runLog(ctx context.Context, cfg Config, args []string) (int, error) {
+	fs := newFlagSet("log", "<jobId>")
+	follow := fs.Bool("f", false, "wait for the job to finish if it has not yet, runners upload the log once a job is done")
+	lines := fs.Int("n", 0, "only print the last n lines")
+	output := fs.String("o", "", "write the log to this file instead of printing it")
+	interval := fs.Duration("interval", defaultInterval, "how often to poll the controller while waiting")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitError, err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitError, errors.New("expected exactly one job id")
+	}
+	jobIds, err := parseJobIds(fs.Args())
+	if err != nil {
+		return ExitError, err
+	}
+	client, err := cfg.Client()
+	if err != nil {
+		return ExitError, err
+	}
+
+	code := ExitSucceeded
+	if *follow {
+		code, err = watchJobs(ctx, client, jobIds, *interval)
+		if err != nil {
+			return ExitError, err
+		}
+	}
+
+	content, err := client.JobLog(ctx, jobIds[0])
+	if err != nil {
+		if api.IsStatus(err, http.StatusNotFound) {
+			return ExitError, fmt.Errorf("job #%d has no log yet", jobIds[0])
+		}
+		return ExitError, err
+	}
+	defer content.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return ExitError, err
+		}
+		defer file.Close()
+		w = file
+	}
+	if *lines > 0 {
+		err = copyTail(w, content, *lines)
+	} else {
+		_, err = io.Copy(w, content)
+	}
+	if err != nil {
+		return ExitError, err
+	}
+	return code, nil
+}
+
+// copyTail copies the last n lines of r to w
+func copyTail(w io.Writer, r io.Reader, n int) error {
+	tail := make([]string, 0, n)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if len(tail) == n {
+			tail = tail[1:]
+		}
+		tail = append(tail, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, line := range tail {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runCancel(ctx context.Context, cfg Config, args []string) (int, error) {
+	fs := newFlagSet("cancel", "<jobId>...")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitError, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitError, errors.New("no job given")
+	}
+	jobIds, err := parseJobIds(fs.Args())
+	if err != nil {
+		return ExitError, err
+	}
+	client, err := cfg.Client()
+	if err != nil {
+		return ExitError, err
+	}
+	for _, jobId := range jobIds {
+		err = client.Cancel(ctx, jobId)
+		if err != nil {
+			return ExitError, fmt.Errorf("job #%d: %w", jobId, err)
+		}
+		fmt.Fprintf(os.Stderr, "job #%d cancelled\n", jobId)
+	}
+	return ExitSucceeded, nil
+}
+
+func runRerun(ctx context.Context, cfg Config, args []string) (int, error) {
+	fs := newFlagSet("rerun", "<jobId>...")
+	watch := fs.Bool("watch", false, "wait for the new jobs to finish and exit with their result")
+	interval := fs.Duration("interval", defaultInterval, "how often to poll the controller while watching")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitError, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitError, errors.New("no job given")
+	}
+	jobIds, err := parseJobIds(fs.Args())
+	if err != nil {
+		return ExitError, err
+	}
+	client, err := cfg.Client()
+	if err != nil {
+		return ExitError, err
+	}
+	newJobIds := []int64{}
+	for _, jobId := range jobIds {
+		resp, err := client.Rerun(ctx, jobId)
+		if err != nil {
+			return ExitError, fmt.Errorf("job #%d: %w", jobId, err)
+		}
+		fmt.Println(resp.Id)
+		newJobIds = append(newJobIds, resp.Id)
+	}
+
+	if !*watch {
+		return ExitSucceeded, nil
+	}
+	return watchJobs(ctx, client, newJobIds, *interval)
+}
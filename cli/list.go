@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runList(ctx context.Context, cfg Config, args []string) (int, error) {
+	fs := newFlagSet("list", "projects | entities [<project>/]<key> | queue")
+	asJson := fs.Bool("json", false, "print the response of the controller as JSON")
+	before := fs.Int64("before", 0, "continue a listing at this cursor, printed at the end of the previous page")
+	tag := fs.String("tag", "", "only list queued jobs with this tag")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitError, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitError, errors.New("nothing to list given")
+	}
+	client, err := cfg.Client()
+	if err != nil {
+		return ExitError, err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var resp any
+	var more []string
+	switch fs.Arg(0) {
+	case "projects":
+		projects, err := client.Projects(ctx)
+		if err != nil {
+			return ExitError, err
+		}
+		resp = projects
+		fmt.Fprintln(w, "SLUG\tNAME")
+		for _, project := range projects.Projects {
+			fmt.Fprintf(w, "%s\t%s\n", project.Slug, project.Name)
+		}
+	case "entities":
+		if fs.NArg() != 2 {
+			return ExitError, errors.New("expected [<project>/]<key>")
+		}
+		project, key, found := strings.Cut(fs.Arg(1), "/")
+		if !found {
+			project, key = cfg.Project, fs.Arg(1)
+		}
+		if project == "" || key == "" {
+			return ExitError, errors.New("expected [<project>/]<key>")
+		}
+		entities, err := client.Entities(ctx, project, key, *before)
+		if err != nil {
+			return ExitError, err
+		}
+		resp = entities
+		if entities.Older > 0 {
+			more = append(more, fmt.Sprintf("more available with -before %d", entities.Older))
+		}
+		fmt.Fprintln(w, "ENTITY\tCREATED")
+		for _, entity := range entities.Entities {
+			fmt.Fprintf(w, "%s/%s/%s\t%s\n", project, entity.Key, entity.Val, formatTime(entity.Created))
+		}
+	case "queue":
+		// every tag is paged on its own, a single cursor would skip jobs of the other tags
+		if *before > 0 && *tag == "" {
+			return ExitError, errors.New("-before needs -tag when listing the queue")
+		}
+		queue, err := client.Queue(ctx, *tag, *before)
+		if err != nil {
+			return ExitError, err
+		}
+		resp = queue
+		fmt.Fprintln(w, "JOB\tNAME\tENTITY\tTAG\tSTATUS\tCREATED")
+		for _, queueTag := range queue.Tags {
+			for _, job := range queueTag.Jobs {
+				fmt.Fprintf(w, "%d\t%s\t%s/%s/%s\t%s\t%s\t%s\n", job.Id, job.Name, job.Project, job.EntityKey, job.EntityVal, job.Tag, job.Status, formatTime(job.Created))
+			}
+			if queueTag.Older > 0 {
+				more = append(more, fmt.Sprintf("more with tag %s available with -tag %s -before %d", queueTag.Tag, queueTag.Tag, queueTag.Older))
+			}
+		}
+		for _, queueTag := range queue.Tags {
+			if queueTag.Starving {
+				fmt.Fprintf(os.Stderr, "warning: %d jobs with tag %s are queued but no runner with that tag has checked in recently\n", queueTag.Count, queueTag.Tag)
+			}
+		}
+	default:
+		return ExitError, fmt.Errorf("unknown listing %s, available: projects, entities, queue", fs.Arg(0))
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(resp)
+		if err != nil {
+			return ExitError, err
+		}
+		return ExitSucceeded, nil
+	}
+	err = w.Flush()
+	if err != nil {
+		return ExitError, err
+	}
+	for _, line := range more {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	return ExitSucceeded, nil
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).Format("2006-01-02 15:04:05")
+}
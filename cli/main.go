@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// Exit codes of the aura command
+const (
+	ExitSucceeded = 0
+	ExitFailed    = 1
+	ExitCancelled = 2
+	ExitError     = 3
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, cfg Config, args []string) (int, error)
+}
+
+var commands = []command{
+	{"submit", "submit jobs from flags or a JSON/YAML file", runSubmit},
+	{"watch", "wait for a job or all jobs of an entity to finish", runWatch},
+	{"log", "print or download the log of a job", runLog},
+	{"cancel", "cancel jobs that have not started yet", runCancel},
+	{"rerun", "submit jobs again", runRerun},
+	{"list", "list projects, entities or the job queue", runList},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: aura [flags] <command> [command flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-8s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	configFile := flag.String("config", "", "config file (default $AURA_CONFIG or aura/config.yaml in the user config directory)")
+	controller := flag.String("controller", "", "URL of the controller, overrides the config")
+	key := flag.String("key", "", "key to authenticate with, overrides the config")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(ExitError)
+	}
+
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitError)
+	}
+	if *controller != "" {
+		cfg.Controller = *controller
+	}
+	if *key != "" {
+		cfg.Key = *key
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		code, err := c.run(ctx, cfg, flag.Args()[1:])
+		if errors.Is(err, flag.ErrHelp) {
+			code = ExitSucceeded
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "aura %s: %s\n", name, err)
+			code = ExitError
+		}
+		stop()
+		os.Exit(code)
+	}
+	fmt.Fprintf(os.Stderr, "aura: unknown command %s, available: %s\n", name, commandNames())
+	os.Exit(ExitError)
+}
+
+func commandNames() string {
+	names := []string{}
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// newFlagSet returns a flag set for a command that returns errors instead of exiting
+func newFlagSet(name string, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("aura "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: aura %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// multiFlag collects the values of a flag that may be given several times
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+	"gopkg.in/yaml.v3"
+)
+
+func runSubmit(ctx context.Context, cfg Config, args []string) (int, error) {
+	fs := newFlagSet("submit", "")
+	file := fs.String("file", "", "JSON or YAML file containing a submit request or a list of them, - reads from stdin")
+	project := fs.String("project", "", "slug of the project (default from the config)")
+	entity := fs.String("entity", "", "entity as key=val (default the entity of the current job)")
+	name := fs.String("name", "", "name of the job")
+	cmd := fs.String("cmd", "", "command to run")
+	tag := fs.String("tag", "", "tag used to find a runner")
+	earliestStart := fs.Duration("delay", 0, "do not start the job before this duration has passed")
+	watch := fs.Bool("watch", false, "wait for the jobs to finish and exit with their result")
+	interval := fs.Duration("interval", defaultInterval, "how often to poll the controller while watching")
+	var env, collections, precedingJobs multiFlag
+	fs.Var(&env, "env", "environment variable as KEY=VALUE, may be repeated")
+	fs.Var(&collections, "collection", "collection to include the entity in as key=val, may be repeated")
+	fs.Var(&precedingJobs, "after", "id of a job that has to succeed first, may be repeated")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitError, err
+	}
+	if fs.NArg() > 0 {
+		return ExitError, fmt.Errorf("unexpected argument %s", fs.Arg(0))
+	}
+
+	reqs := []api.SubmitRequest{{}}
+	if *file != "" {
+		reqs, err = readSubmitFile(*file)
+		if err != nil {
+			return ExitError, err
+		}
+	}
+
+	// flags override the values from the file, the config fills in what is still missing
+	for i := range reqs {
+		req := &reqs[i]
+		if *project != "" {
+			req.Project = *project
+		} else if req.Project == "" {
+			req.Project = cfg.Project
+		}
+		if *entity != "" {
+			req.EntityKey, req.EntityVal, err = splitPair(*entity)
+			if err != nil {
+				return ExitError, fmt.Errorf("invalid -entity: %w", err)
+			}
+		} else if req.EntityKey == "" && req.EntityVal == "" {
+			req.EntityKey, req.EntityVal = cfg.EntityKey, cfg.EntityVal
+		}
+		if *name != "" {
+			req.Name = *name
+		}
+		if *cmd != "" {
+			req.Cmd = *cmd
+		}
+		if *tag != "" {
+			req.Tag = *tag
+		}
+		if len(env) > 0 {
+			lines := []string{}
+			if req.Env != "" {
+				lines = append(lines, req.Env)
+			}
+			req.Env = strings.Join(append(lines, env...), "\n")
+		}
+		for _, collection := range collections {
+			key, val, err := splitPair(collection)
+			if err != nil {
+				return ExitError, fmt.Errorf("invalid -collection: %w", err)
+			}
+			if req.Collections == nil {
+				req.Collections = map[string]string{}
+			}
+			req.Collections[key] = val
+		}
+		for _, precedingJob := range precedingJobs {
+			jobId, err := strconv.ParseInt(precedingJob, 10, 64)
+			if err != nil {
+				return ExitError, fmt.Errorf("invalid -after: %w", err)
+			}
+			req.PrecedingJobs = append(req.PrecedingJobs, jobId)
+		}
+		if *earliestStart > 0 {
+			start := time.Now().Add(*earliestStart).Unix()
+			req.EarliestStart = &start
+		}
+		if req.ParentJob == nil && cfg.IsJobKey() && cfg.JobId > 0 {
+			parentJob := cfg.JobId
+			req.ParentJob = &parentJob
+		}
+		if req.Project == "" {
+			return ExitError, errors.New("no project given, use -project or set project in the config")
+		}
+		if req.Name == "" {
+			return ExitError, errors.New("no name given")
+		}
+	}
+
+	client, err := cfg.Client()
+	if err != nil {
+		return ExitError, err
+	}
+	jobIds := []int64{}
+	for _, req := range reqs {
+		resp, err := client.Submit(ctx, req)
+		if err != nil {
+			return ExitError, err
+		}
+		fmt.Println(resp.Id)
+		jobIds = append(jobIds, resp.Id)
+	}
+
+	if !*watch {
+		return ExitSucceeded, nil
+	}
+	return watchJobs(ctx, client, jobIds, *interval)
+}
+
+// readSubmitFile reads a single submit request or a list of them.
+// YAML documents are converted to JSON first so the field names match the API.
+func readSubmitFile(file string) ([]api.SubmitRequest, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var content any
+	err = yaml.Unmarshal(data, &content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	jsonData, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	reqs := []api.SubmitRequest{}
+	if _, isList := content.([]any); isList {
+		err = json.Unmarshal(jsonData, &reqs)
+	} else {
+		var req api.SubmitRequest
+		err = json.Unmarshal(jsonData, &req)
+		reqs = append(reqs, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs", file)
+	}
+	return reqs, nil
+}
+
+// splitPair splits key=val
+func splitPair(s string) (string, string, error) {
+	key, val, found := strings.Cut(s, "=")
+	if !found || key == "" || val == "" {
+		return "", "", fmt.Errorf("expected key=val, got %q", s)
+	}
+	return key, val, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSubmitFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		jobs    []string
+		valid   bool
+	}{
+		{"single.yaml", "project: colors\nentityKey: rev\nentityVal: abc\nname: build\ncmd: make\ntag: native,linux\n", []string{"build"}, true},
+		{"list.yaml", "- {project: colors, entityKey: rev, entityVal: abc, name: build, cmd: make}\n- {project: colors, entityKey: rev, entityVal: abc, name: test, cmd: make test}\n", []string{"build", "test"}, true},
+		{"single.json", `{"project": "colors", "entityKey": "rev", "entityVal": "abc", "name": "build", "cmd": "make"}`, []string{"build"}, true},
+		{"list.json", `[{"name": "build"}, {"name": "lint"}]`, []string{"build", "lint"}, true},
+		{"empty.json", `[]`, nil, false},
+		{"invalid.yaml", "name: [build\n", nil, false},
+		{"wrong-type.json", `{"name": ["build"]}`, nil, false},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), test.name)
+		err := os.WriteFile(file, []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs, err := readSubmitFile(file)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", test.name, reqs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if len(reqs) != len(test.jobs) {
+			t.Errorf("%s: expected %d jobs, got %+v", test.name, len(test.jobs), reqs)
+			continue
+		}
+		for i, req := range reqs {
+			if req.Name != test.jobs[i] {
+				t.Errorf("%s: expected job %s, got %+v", test.name, test.jobs[i], req)
+			}
+		}
+	}
+
+	reqs, err := readSubmitFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Errorf("expected error for a missing file, got %+v", reqs)
+	}
+	file := filepath.Join(t.TempDir(), "fields.yaml")
+	err = os.WriteFile(file, []byte("project: colors\nentityKey: rev\nentityVal: abc\nname: build\ncmd: make\nenv: A=1\ntag: native,linux\ncollections: {ref: main}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs, err = readSubmitFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := reqs[0]
+	if req.Project != "colors" || req.EntityKey != "rev" || req.EntityVal != "abc" || req.Cmd != "make" || req.Env != "A=1" || req.Tag != "native,linux" || req.Collections["ref"] != "main" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestSplitPair(t *testing.T) {
+	tests := []struct {
+		s     string
+		key   string
+		val   string
+		valid bool
+	}{
+		{"ref=main", "ref", "main", true},
+		{"A=b=c", "A", "b=c", true},
+		{"ref", "", "", false},
+		{"=main", "", "", false},
+		{"ref=", "", "", false},
+	}
+	for _, test := range tests {
+		key, val, err := splitPair(test.s)
+		if (err == nil) != test.valid || key != test.key || val != test.val {
+			t.Errorf("splitPair(%q) = %q, %q, %v", test.s, key, val, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// defaultInterval is how often the controller is polled while waiting for jobs
+const defaultInterval = 5 * time.Second
+
+func runWatch(ctx context.Context, cfg Config, args []string) (int, error) {
+	fs := newFlagSet("watch", "<jobId>... | [<project>/]<key>/<val>")
+	interval := fs.Duration("interval", defaultInterval, "how often to poll the controller")
+	err := fs.Parse(args)
+	if err != nil {
+		return ExitError, err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitError, errors.New("no job or entity given")
+	}
+	client, err := cfg.Client()
+	if err != nil {
+		return ExitError, err
+	}
+
+	if strings.Contains(fs.Arg(0), "/") {
+		if fs.NArg() > 1 {
+			return ExitError, errors.New("only one entity can be watched at a time")
+		}
+		project, key, val, err := parseEntity(fs.Arg(0), cfg.Project)
+		if err != nil {
+			return ExitError, err
+		}
+		return watchEntity(ctx, client, project, key, val, *interval)
+	}
+	jobIds, err := parseJobIds(fs.Args())
+	if err != nil {
+		return ExitError, err
+	}
+	return watchJobs(ctx, client, jobIds, *interval)
+}
+
+// watchJobs polls the jobs until all of them are done and returns the exit code for their result
+func watchJobs(ctx context.Context, client *api.Client, jobIds []int64, interval time.Duration) (int, error) {
+	statuses := map[int64]string{}
+	for {
+		jobs := []api.JobInfo{}
+		for _, jobId := range jobIds {
+			job, err := client.JobDetails(ctx, jobId)
+			if err != nil {
+				return ExitError, err
+			}
+			jobs = append(jobs, job)
+		}
+		done := reportJobs(jobs, statuses)
+		if done {
+			return exitCodeFor(jobs), nil
+		}
+		err := sleep(ctx, interval)
+		if err != nil {
+			return ExitError, err
+		}
+	}
+}
+
+// watchEntity polls the entity until all of its jobs are done and returns the exit code for their result.
+// Only the most recent job with each name counts towards the result, just like on the entity page.
+func watchEntity(ctx context.Context, client *api.Client, project string, key string, val string, interval time.Duration) (int, error) {
+	statuses := map[int64]string{}
+	for {
+		entity, err := client.Entity(ctx, project, key, val, 0)
+		if err != nil {
+			return ExitError, err
+		}
+		if entity.Collection {
+			return ExitError, fmt.Errorf("%s/%s/%s is a collection, watch one of its entities instead", project, key, val)
+		}
+		done := reportJobs(entity.Jobs, statuses)
+		if done && len(entity.Jobs) > 0 {
+			latest := map[string]api.JobInfo{}
+			for _, job := range entity.Jobs {
+				latest[job.Name] = job
+			}
+			jobs := []api.JobInfo{}
+			for _, job := range latest {
+				jobs = append(jobs, job)
+			}
+			return exitCodeFor(jobs), nil
+		}
+		err = sleep(ctx, interval)
+		if err != nil {
+			return ExitError, err
+		}
+	}
+}
+
+// reportJobs prints every job whose status changed since the last call and reports whether all jobs are done
+func reportJobs(jobs []api.JobInfo, statuses map[int64]string) bool {
+	done := true
+	for _, job := range jobs {
+		if statuses[job.Id] != job.Status {
+			statuses[job.Id] = job.Status
+			fmt.Fprintf(os.Stderr, "%s #%d %s: %s\n", time.Now().Format("15:04:05"), job.Id, job.Name, describeJob(job))
+		}
+		if !job.Done() {
+			done = false
+		}
+	}
+	return done
+}
+
+func describeJob(job api.JobInfo) string {
+	switch job.Status {
+	case api.JobStatusStarted:
+		return fmt.Sprintf("started on %s", job.Runner)
+	case api.JobStatusSucceeded, api.JobStatusFailed:
+		desc := fmt.Sprintf("%s with exit code %d after %s", job.Status, job.ExitCode, time.Duration(job.Ended-job.Started)*time.Second)
+		if job.Message != "" {
+			desc += " (" + job.Message + ")"
+		}
+		return desc
+	default:
+		return job.Status
+	}
+}
+
+// exitCodeFor returns ExitFailed if any job failed, ExitCancelled if any job was cancelled and ExitSucceeded otherwise
+func exitCodeFor(jobs []api.JobInfo) int {
+	code := ExitSucceeded
+	for _, job := range jobs {
+		if job.Status == api.JobStatusFailed {
+			return ExitFailed
+		} else if job.Status == api.JobStatusCancelled {
+			code = ExitCancelled
+		}
+	}
+	return code
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseEntity parses project/key/val, or key/val in the default project
+func parseEntity(s string, defaultProject string) (string, string, string, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) == 2 && defaultProject != "" {
+		parts = append([]string{defaultProject}, parts...)
+	}
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("expected project/key/val, got %q", s)
+	}
+	for _, part := range parts {
+		if !api.SlugRegex.MatchString(part) {
+			return "", "", "", fmt.Errorf("expected project/key/val, got %q", s)
+		}
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func parseJobIds(args []string) ([]int64, error) {
+	jobIds := []int64{}
+	for _, arg := range args {
+		jobId, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job id %q", arg)
+		}
+		jobIds = append(jobIds, jobId)
+	}
+	return jobIds, nil
+}
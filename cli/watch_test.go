@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+func TestParseEntity(t *testing.T) {
+	tests := []struct {
+		s              string
+		defaultProject string
+		expected       []string
+		valid          bool
+	}{
+		{"colors/rev/abc", "", []string{"colors", "rev", "abc"}, true},
+		{"colors/rev/abc", "shapes", []string{"colors", "rev", "abc"}, true},
+		{"rev/abc", "colors", []string{"colors", "rev", "abc"}, true},
+		{"rev/abc", "", nil, false},
+		{"abc", "colors", nil, false},
+		{"colors/rev/abc/def", "", nil, false},
+		{"colors/rev/a b", "", nil, false},
+		{"colors//abc", "", nil, false},
+	}
+	for _, test := range tests {
+		project, key, val, err := parseEntity(test.s, test.defaultProject)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("parseEntity(%q, %q): expected error, got %s/%s/%s", test.s, test.defaultProject, project, key, val)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual([]string{project, key, val}, test.expected) {
+			t.Errorf("parseEntity(%q, %q) = %s/%s/%s, %v", test.s, test.defaultProject, project, key, val, err)
+		}
+	}
+}
+
+func TestParseJobIds(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []int64
+		valid    bool
+	}{
+		{[]string{"42"}, []int64{42}, true},
+		{[]string{"#42", "43"}, []int64{42, 43}, true},
+		{[]string{}, []int64{}, true},
+		{[]string{"42", "abc"}, nil, false},
+		{[]string{"##42"}, nil, false},
+	}
+	for _, test := range tests {
+		jobIds, err := parseJobIds(test.args)
+		if (err == nil) != test.valid || (test.valid && !reflect.DeepEqual(jobIds, test.expected)) {
+			t.Errorf("parseJobIds(%v) = %v, %v", test.args, jobIds, err)
+		}
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		expected int
+	}{
+		{"none", nil, ExitSucceeded},
+		{"succeeded", []string{api.JobStatusSucceeded, api.JobStatusSucceeded}, ExitSucceeded},
+		{"cancelled", []string{api.JobStatusSucceeded, api.JobStatusCancelled}, ExitCancelled},
+		{"failed", []string{api.JobStatusCancelled, api.JobStatusFailed, api.JobStatusSucceeded}, ExitFailed},
+	}
+	for _, test := range tests {
+		jobs := []api.JobInfo{}
+		for i, status := range test.statuses {
+			jobs = append(jobs, api.JobInfo{Id: int64(i + 1), Status: status})
+		}
+		code := exitCodeFor(jobs)
+		if code != test.expected {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.expected, code)
+		}
+	}
+}
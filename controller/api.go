@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -16,15 +18,39 @@ import (
 	"github.com/unnamedtiger/aura/api"
 )
 
-var runnerCheckins map[string]time.Time
-var tagCheckins map[string]time.Time
-
 func checkAdminAuth(auth string) (bool, error) {
-	admin, err := LoadAdmin()
+	return checkKeys(KeyKindAdmin, 0, auth, time.Now())
+}
+
+// checkKeys checks auth against all usable keys of the owner and records the use of the matching key
+func checkKeys(kind string, ownerId int64, auth string, now time.Time) (bool, error) {
+	keys, err := FindKeys(kind, ownerId)
 	if err != nil {
 		return false, err
 	}
-	return CompareHashAndPassword(admin.Auth, auth)
+	for _, key := range keys {
+		if !key.Usable(now) {
+			continue
+		}
+		authOk, err := CompareHashAndPassword(key.Auth, auth)
+		if err != nil {
+			return false, err
+		}
+		if authOk {
+			// the use is recorded at most once a minute to spare the database a write on every request
+			if now.Sub(key.LastUsed) >= time.Minute {
+				err = MarkKeyUsed(key.Id, now)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			if isLegacyHash(key.Auth) {
+				upgradeKeyHash(key.Id, auth)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func checkJobAuth(jobAuth []byte, auth string) (bool, error) {
@@ -37,9 +63,22 @@ func checkJobAuth(jobAuth []byte, auth string) (bool, error) {
 	}
 }
 
-func checkProjectAuth(projectAuth []byte, auth string) (bool, error) {
+// checkJobProjectAuth checks auth against the key of the project the job belongs to
+func checkJobProjectAuth(job Job, auth string) (bool, error) {
+	entity, err := LoadEntity(job.EntityId)
+	if err != nil {
+		return false, err
+	}
+	project, err := LoadProject(entity.ProjectId)
+	if err != nil {
+		return false, err
+	}
+	return checkProjectAuth(project.Id, auth)
+}
+
+func checkProjectAuth(projectId int64, auth string) (bool, error) {
 	if strings.HasPrefix(auth, PrefixProject) {
-		return CompareHashAndPassword(projectAuth, auth)
+		return checkKeys(KeyKindProject, projectId, auth, time.Now())
 	} else if strings.HasPrefix(auth, PrefixAdmin) {
 		return checkAdminAuth(auth)
 	} else {
@@ -47,9 +86,9 @@ func checkProjectAuth(projectAuth []byte, auth string) (bool, error) {
 	}
 }
 
-func checkRunnerAuth(runnerAuth []byte, auth string) (bool, error) {
+func checkRunnerAuth(runnerId int64, auth string) (bool, error) {
 	if strings.HasPrefix(auth, PrefixRunner) {
-		return CompareHashAndPassword(runnerAuth, auth)
+		return checkKeys(KeyKindRunner, runnerId, auth, time.Now())
 	} else if strings.HasPrefix(auth, PrefixAdmin) {
 		return checkAdminAuth(auth)
 	} else {
@@ -75,29 +114,49 @@ func respondError(w http.ResponseWriter, code int, msg string) {
 	respond(w, code, api.Status{Code: code, Message: msg})
 }
 
-func RouteApiJob(w http.ResponseWriter, r *http.Request) {
+// versioned serves a path below /api/v1 with the handler of the unversioned path
+func versioned(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path = "/api" + strings.TrimPrefix(r.URL.Path, "/api/v1")
+		r2 := *r
+		r2.URL = &u
+		handler(w, &r2)
+	}
+}
+
+// protocolCacheLimits is the protocol level from which on runners handle job caches and limits
+const protocolCacheLimits = 1
+
+// compatibilityMode reports whether a runner only gets jobs it can run without caches and limits
+func compatibilityMode(protocol int64) bool {
+	return protocol < protocolCacheLimits
+}
+
+var allowedCacheRegex = regexp.MustCompile(`^(\d+)/([0-9A-Za-z-_:\.]{1,260})$`)
+
+func RouteApiCache(w http.ResponseWriter, r *http.Request) {
 	t := time.Now()
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println(err)
-		respondError(w, http.StatusInternalServerError, "internal server error")
+	matches := allowedCacheRegex.FindStringSubmatch(strings.TrimPrefix(r.URL.Path, "/api/cache/"))
+	if matches == nil {
+		respondError(w, http.StatusBadRequest, "invalid cache path")
 		return
 	}
-	var req api.JobRequest
-	err = json.Unmarshal(body, &req)
+	jobId, err := strconv.ParseInt(matches[1], 10, 64)
 	if err != nil {
 		log.Println(err)
-		respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+		respondError(w, http.StatusBadRequest, "invalid job id")
 		return
 	}
-	runner, err := FindRunnerByName(req.Name)
+	key := matches[2]
+	job, err := LoadJob(jobId)
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
-			respondError(w, http.StatusBadRequest, "unknown runner")
+			respondError(w, http.StatusBadRequest, "unknown job")
 			return
 		}
 		log.Println(err)
@@ -110,7 +169,7 @@ func RouteApiJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
-	authOk, err := checkRunnerAuth(runner.Auth, authHeader)
+	authOk, err := checkJobAuth(job.Auth, authHeader)
 	if err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, "internal server error")
@@ -120,52 +179,132 @@ func RouteApiJob(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-
-	status := StatusFailed
-	if req.ExitCode == 0 {
-		status = StatusSucceeded
-	}
-	err = MarkJobDone(req.Id, status, req.ExitCode, t)
+	entity, err := LoadEntity(job.EntityId)
 	if err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
-	go handlePrecedingJobCompleted(req.Id, status, t)
-	respond(w, http.StatusOK, api.JobResponse{})
-}
 
-func handlePrecedingJobCompleted(jobId int64, status int, now time.Time) {
-	if status == StatusFailed || status == StatusCancelled {
-		succedingJobIds, err := FindSuccedingJobIds(jobId)
+	if r.Method == http.MethodPost {
+		err = SaveCacheEntry(entity.ProjectId, key, r.Body, t)
 		if err != nil {
+			if errors.Is(err, errCacheEntryTooLarge) {
+				respondError(w, http.StatusRequestEntityTooLarge, "cache entry too large")
+				return
+			}
 			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
-		for _, succedingJobId := range succedingJobIds {
-			err = MarkJobDone(succedingJobId, StatusCancelled, 0, now)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			handlePrecedingJobCompleted(succedingJobId, StatusCancelled, now)
+		respond(w, http.StatusOK, api.CacheResponse{})
+		return
+	}
+
+	restoreKeys := r.URL.Query()["restore"]
+	for _, restoreKey := range restoreKeys {
+		if !slugRegex.MatchString(restoreKey) {
+			respondError(w, http.StatusBadRequest, "invalid restore key")
+			return
 		}
 	}
-	err := MarkPrecedingJobCompleted(jobId)
+	entry, err := FindCacheEntryForRestore(entity.ProjectId, key, restoreKeys)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusNotFound, "cache miss")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	file, err := os.Open(cacheEntryPath(entry.Id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) { // evicted in the meantime
+			respondError(w, http.StatusNotFound, "cache miss")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	defer file.Close()
+	err = MarkCacheEntryUsed(entry.Id, t)
 	if err != nil {
 		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(api.CacheKeyHeader, entry.Key)
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println(err)
+	}
+}
 
-	job, err := LoadJob(jobId)
+func RouteApiCancel(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
-	UpdateEntityStatus(job.EntityId)
+	var req api.CancelRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+		return
+	}
+	job, err := LoadJob(req.Id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusBadRequest, "unknown job")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) == 0 {
+		respondError(w, http.StatusUnauthorized, "missing authorization header")
+		return
+	}
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	authOk, err := checkJobProjectAuth(job, authHeader)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !authOk {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	err = MarkJobCancelled(job.Id, t)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusConflict, "job is not queued anymore")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	go handlePrecedingJobCompleted(job.Id, StatusCancelled, t)
+	respond(w, http.StatusOK, api.CancelResponse{})
 }
 
-func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
+func RouteApiJob(w http.ResponseWriter, r *http.Request) {
 	t := time.Now()
 	if r.Method != http.MethodPost {
 		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -177,7 +316,7 @@ func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
-	var req api.RunnerRequest
+	var req api.JobRequest
 	err = json.Unmarshal(body, &req)
 	if err != nil {
 		log.Println(err)
@@ -200,7 +339,7 @@ func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
-	authOk, err := checkRunnerAuth(runner.Auth, authHeader)
+	authOk, err := checkRunnerAuth(runner.Id, authHeader)
 	if err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, "internal server error")
@@ -210,54 +349,775 @@ func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	runnerCheckins[req.Name] = t
 
-	candidates := []int64{}
-	for _, tag := range req.Tags {
-		tagCheckins[tag] = t
-		limit := int64(req.Limit - len(candidates))
-		if limit > 0 {
-			jobIds, err := FindJobsForRunner(tag, limit, t)
-			if err != nil {
-				log.Println(err)
-				respondError(w, http.StatusInternalServerError, "internal server error")
-				return
-			}
-			candidates = append(candidates, jobIds...)
+	presence.CheckIn(req.Name, nil, t)
+
+	status := StatusFailed
+	if req.ExitCode == 0 {
+		status = StatusSucceeded
+	}
+	err = MarkJobDone(req.Id, status, req.ExitCode, req.Message, t)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	go handlePrecedingJobCompleted(req.Id, status, t)
+	respond(w, http.StatusOK, api.JobResponse{})
+}
+
+var allowedJobsRegex = regexp.MustCompile(`^(\d+)(/diagnostics|/log)?$`)
+
+func RouteApiJobs(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	matches := allowedJobsRegex.FindStringSubmatch(strings.TrimPrefix(r.URL.Path, "/api/jobs/"))
+	if matches == nil {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	jobId, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	job, err := LoadJob(jobId)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusNotFound, "unknown job")
+			return
 		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
 	}
-	jobs := []api.RunnerResponseJob{}
-	for _, candidate := range candidates {
-		pass, hash, err := GenerateRandom(PrefixJob)
+
+	switch matches[2] {
+	case "/diagnostics":
+		diagnostics, err := diagnoseJob(job, t)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
-		jobObj, err := ReserveJobForRunner(candidate, hash, runner.Id, t)
+		respond(w, http.StatusOK, diagnostics)
+	case "/log":
+		file, err := os.Open(filepath.Join("artifacts", fmt.Sprintf("%d", job.Id), "log"))
 		if err != nil {
-			if errors.Is(err, ErrNotFound) {
-				continue
+			if errors.Is(err, os.ErrNotExist) {
+				respondError(w, http.StatusNotFound, "no log")
+				return
 			}
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
-		entity, err := LoadEntity(jobObj.EntityId)
+		defer file.Close()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err = io.Copy(w, file)
+		if err != nil {
+			log.Println(err)
+		}
+	default:
+		infos, err := jobInfos([]Job{job})
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
-		project, err := LoadProject(entity.ProjectId)
+		respond(w, http.StatusOK, infos[0])
+	}
+}
+
+// jobInfos converts jobs for the read endpoints, looking up every entity, project and runner only once
+func jobInfos(jobs []Job) ([]api.JobInfo, error) {
+	var err error
+	entities := map[int64]EntityOrCollection{}
+	projects := map[int64]Project{}
+	runners := map[int64]Runner{}
+	results := []api.JobInfo{}
+	for _, job := range jobs {
+		entity, found := entities[job.EntityId]
+		if !found {
+			entity, err = LoadEntity(job.EntityId)
+			if err != nil {
+				return nil, err
+			}
+			entities[job.EntityId] = entity
+		}
+		project, found := projects[entity.ProjectId]
+		if !found {
+			project, err = LoadProject(entity.ProjectId)
+			if err != nil {
+				return nil, err
+			}
+			projects[entity.ProjectId] = project
+		}
+		runnerName := ""
+		if job.Runner > 0 {
+			runner, found := runners[job.Runner]
+			if !found {
+				runner, err = LoadRunner(job.Runner)
+				if err != nil {
+					return nil, err
+				}
+				runners[job.Runner] = runner
+			}
+			runnerName = runner.Name
+		}
+		precedingJobs, err := FindPrecedingJobs(job.Id)
+		if err != nil {
+			return nil, err
+		}
+		precedingJobIds := []int64{}
+		for _, precedingJob := range precedingJobs {
+			precedingJobIds = append(precedingJobIds, precedingJob.Id)
+		}
+		results = append(results, api.JobInfo{
+			Id:            job.Id,
+			Project:       project.Slug,
+			EntityKey:     entity.Key,
+			EntityVal:     entity.Val,
+			Name:          job.Name,
+			Status:        jobStatus(job.Status),
+			Cmd:           job.Cmd,
+			Tag:           job.Tag,
+			Created:       job.Created.Unix(),
+			EarliestStart: job.EarliestStart.Unix(),
+			Started:       job.Started.Unix(),
+			Ended:         job.Ended.Unix(),
+			Runner:        runnerName,
+			ExitCode:      job.ExitCode,
+			Message:       job.Message,
+			PrecedingJobs: precedingJobIds,
+		})
+	}
+	return results, nil
+}
+
+func entityInfo(entity EntityOrCollection) api.EntityInfo {
+	return api.EntityInfo{Key: entity.Key, Val: entity.Val, Created: entity.Created.Unix()}
+}
+
+func entityInfos(eocList []EntityOrCollection) []api.EntityInfo {
+	results := []api.EntityInfo{}
+	for _, eoc := range eocList {
+		results = append(results, entityInfo(eoc))
+	}
+	return results
+}
+
+// parseBefore returns the before query parameter used for pagination, 0 if it is not set
+func parseBefore(r *http.Request) (int64, error) {
+	query := r.URL.Query()
+	if !query.Has("before") {
+		return 0, nil
+	}
+	return strconv.ParseInt(query.Get("before"), 10, 64)
+}
+
+func RouteApiProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/api/projects")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		projects, err := LoadProjects()
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		resp := api.ProjectsResponse{Projects: []api.ProjectInfo{}}
+		for _, project := range projects {
+			resp.Projects = append(resp.Projects, api.ProjectInfo{Name: project.Name, Slug: project.Slug})
+		}
+		respond(w, http.StatusOK, resp)
+		return
+	}
+
+	parts := strings.Split(p, "/")
+	if len(parts) > 3 {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	for _, part := range parts {
+		if !slugRegex.MatchString(part) {
+			respondError(w, http.StatusNotFound, "not found")
+			return
+		}
+	}
+	project, err := FindProjectBySlug(parts[0])
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusNotFound, "unknown project")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	before, err := parseBefore(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid before")
+		return
+	}
+
+	if len(parts) == 1 {
+		RouteApiProjectMain(w, project)
+	} else if len(parts) == 2 {
+		RouteApiProjectKey(w, project, parts[1], before)
+	} else {
+		RouteApiProjectKeyVal(w, project, parts[1], parts[2], before)
+	}
+}
+
+func RouteApiProjectMain(w http.ResponseWriter, project Project) {
+	eocKeys, err := FindEntityOrCollectionKeysByProjectId(project.Id)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := api.ProjectResponse{Project: api.ProjectInfo{Name: project.Name, Slug: project.Slug}, Keys: []api.ProjectKey{}}
+	for _, eocKey := range eocKeys {
+		entities, collections, older, err := findEntityInfos(project, eocKey, 0)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
+		resp.Keys = append(resp.Keys, api.ProjectKey{Key: eocKey, Collections: collections, Entities: entities, Older: older})
+	}
+	respond(w, http.StatusOK, resp)
+}
+
+func RouteApiProjectKey(w http.ResponseWriter, project Project, key string, before int64) {
+	entities, collections, older, err := findEntityInfos(project, key, before)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	respond(w, http.StatusOK, api.EntitiesResponse{Collections: collections, Entities: entities, Older: older})
+}
+
+// findEntityInfos returns a page of entities or collections with the key, whether they are collections and the cursor for the next page
+func findEntityInfos(project Project, key string, before int64) ([]api.EntityInfo, bool, int64, error) {
+	limit := 10
+	eocList, collections, err := FindEntitiesOrCollections(project.Id, key, before, int64(limit+1))
+	if err != nil {
+		return nil, false, 0, err
+	}
+	more := len(eocList) > limit
+	if len(eocList) > limit {
+		eocList = eocList[:limit]
+	}
+	older := int64(0)
+	if more {
+		older = eocList[len(eocList)-1].Created.Unix()
+	}
+	return entityInfos(eocList), collections, older, nil
+}
+
+func RouteApiProjectKeyVal(w http.ResponseWriter, project Project, key string, val string, before int64) {
+	entity, err := FindEntity(project.Id, key, val)
+	if err == nil {
+		jobs, err := FindJobs(entity.Id)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		infos, err := jobInfos(jobs)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		collections, err := FindCollectionsOfEntity(entity.Id)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		respond(w, http.StatusOK, api.EntityResponse{Entity: entityInfo(entity), Jobs: infos, Collections: entityInfos(collections), Entities: []api.EntityInfo{}})
+		return
+	} else if !errors.Is(err, ErrNotFound) {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	collection, err := FindCollection(project.Id, key, val)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusNotFound, "unknown entity")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	limit := 10
+	eocList, err := FindEntitiesInCollection(collection.Id, before, int64(limit+1))
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	more := len(eocList) > limit
+	if len(eocList) > limit {
+		eocList = eocList[:limit]
+	}
+	resp := api.EntityResponse{Entity: entityInfo(collection), Collection: true, Jobs: []api.JobInfo{}, Collections: []api.EntityInfo{}, Entities: entityInfos(eocList)}
+	if more {
+		resp.Older = eocList[len(eocList)-1].Created.Unix()
+	}
+	respond(w, http.StatusOK, resp)
+}
+
+func RouteApiQueue(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	before, err := parseBefore(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid before")
+		return
+	}
+	queue, _, err := loadQueue(r.URL.Query().Get("tag"), before, 10, t)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := api.QueueResponse{Tags: []api.QueueTag{}}
+	for _, group := range queue {
+		infos, err := jobInfos(group.Jobs)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		resp.Tags = append(resp.Tags, api.QueueTag{
+			Tag:           group.Tag,
+			Count:         group.Count,
+			Oldest:        group.Oldest.Unix(),
+			OnlineRunners: group.OnlineRunners,
+			Starving:      group.Starving,
+			Jobs:          infos,
+			Older:         group.Older,
+		})
+	}
+	respond(w, http.StatusOK, resp)
+}
+
+func handlePrecedingJobCompleted(jobId int64, status int, now time.Time) {
+	notifyJobStatus(jobId)
+	if status == StatusFailed || status == StatusCancelled {
+		succedingJobIds, err := FindSuccedingJobIds(jobId)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, succedingJobId := range succedingJobIds {
+			err = MarkJobDone(succedingJobId, StatusCancelled, 0, "", now)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			handlePrecedingJobCompleted(succedingJobId, StatusCancelled, now)
+		}
+	}
+	err := MarkPrecedingJobCompleted(jobId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	job, err := LoadJob(jobId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	UpdateEntityStatus(job.EntityId)
+}
+
+// upgradeKeyHash replaces the bcrypt hash of a key that was just verified, so that it is checked quickly from now on
+func upgradeKeyHash(id int64, auth string) {
+	hash, err := GenerateFromPassword(auth)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = UpdateKeyAuth(id, hash)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// findRegistrationToken returns the usable registration token matching auth
+func findRegistrationToken(auth string, now time.Time) (RegistrationToken, error) {
+	tokens, err := LoadRegistrationTokens()
+	if err != nil {
+		return RegistrationToken{}, err
+	}
+	for _, token := range tokens {
+		if !token.Usable(now) {
+			continue
+		}
+		authOk, err := CompareHashAndPassword(token.Auth, auth)
+		if err != nil {
+			return RegistrationToken{}, err
+		}
+		if authOk {
+			if isLegacyHash(token.Auth) {
+				hash, err := GenerateFromPassword(auth)
+				if err != nil {
+					return RegistrationToken{}, err
+				}
+				err = UpdateRegistrationTokenAuth(token.Id, hash)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			return token, nil
+		}
+	}
+	return RegistrationToken{}, ErrNotFound
+}
+
+func RouteApiRegister(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	var req api.RegisterRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+		return
+	}
+	if !slugRegex.MatchString(req.Name) {
+		respondError(w, http.StatusBadRequest, "invalid name")
+		return
+	}
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) == 0 {
+		respondError(w, http.StatusUnauthorized, "missing authorization header")
+		return
+	}
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	token := RegistrationToken{}
+	if strings.HasPrefix(authHeader, PrefixRegistration) {
+		token, err = findRegistrationToken(authHeader, t)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				respondError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	} else if strings.HasPrefix(authHeader, PrefixAdmin) {
+		authOk, err := checkAdminAuth(authHeader)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !authOk {
+			respondError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	} else {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	pass, hash, err := GenerateRandom(PrefixRunner)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	_, err = CreateRunner(req.Name, hash, token.ProjectId, token.Id, t)
+	if err != nil {
+		if errors.Is(err, ErrExists) {
+			respondError(w, http.StatusConflict, "runner already exists")
+			return
+		}
+		if errors.Is(err, ErrNotFound) { // token used up by another runner in the meantime
+			respondError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	log.Printf("Registered runner %s", req.Name)
+	respond(w, http.StatusOK, api.RegisterResponse{RunnerKey: pass})
+}
+
+func RouteApiRerun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	var req api.RerunRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+		return
+	}
+	job, err := LoadJob(req.Id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusBadRequest, "unknown job")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) == 0 {
+		respondError(w, http.StatusUnauthorized, "missing authorization header")
+		return
+	}
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	authOk, err := checkJobProjectAuth(job, authHeader)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !authOk {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	entity, err := LoadEntity(job.EntityId)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	project, err := LoadProject(entity.ProjectId)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	var jobCache *api.JobCache
+	if job.Cache != "" {
+		jobCache = &api.JobCache{}
+		err = json.Unmarshal([]byte(job.Cache), jobCache)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+	var jobLimits *api.JobLimits
+	if job.Limits != "" {
+		jobLimits = &api.JobLimits{}
+		err = json.Unmarshal([]byte(job.Limits), jobLimits)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+	sub := Submission{
+		SubmitRequest: api.SubmitRequest{
+			Project:   project.Slug,
+			EntityKey: entity.Key,
+			EntityVal: entity.Val,
+			Name:      job.Name,
+			Cmd:       job.Cmd,
+			Env:       job.Env,
+			Tag:       job.Tag,
+			Cache:     jobCache,
+			Limits:    jobLimits,
+		},
+		ProjectId: project.Id,
+	}
+	jobId, submitErr := Submit(sub)
+	if submitErr != nil {
+		log.Printf("%d %s %s\n", submitErr.code, submitErr.msg, submitErr.err)
+		respondError(w, submitErr.code, submitErr.msg)
+		return
+	}
+	respond(w, http.StatusOK, api.RerunResponse{Id: jobId})
+}
+
+func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	if r.Method != http.MethodPost {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	var req api.RunnerRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+		return
+	}
+	runner, err := FindRunnerByName(req.Name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusBadRequest, "unknown runner")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) == 0 {
+		respondError(w, http.StatusUnauthorized, "missing authorization header")
+		return
+	}
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	authOk, err := checkRunnerAuth(runner.Id, authHeader)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !authOk {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if req.Protocol < api.MinProtocolLevel {
+		respondError(w, http.StatusUpgradeRequired, fmt.Sprintf("runner protocol level %d is not supported anymore, update the runner to Aura %s", req.Protocol, api.Version))
+		return
+	} else if req.Protocol > api.ProtocolLevel {
+		respondError(w, http.StatusUpgradeRequired, fmt.Sprintf("runner protocol level %d is newer than this controller supports (%d), update the controller", req.Protocol, api.ProtocolLevel))
+		return
+	}
+	presence.CheckIn(req.Name, req.Tags, t)
+	if int64(req.Protocol) != runner.Protocol || (req.Version != "" && req.Version != runner.Version) {
+		version := req.Version
+		if version == "" {
+			version = runner.Version
+		}
+		err = UpdateRunnerProtocol(runner.Id, version, req.Protocol)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+	if req.Info != nil {
+		err = UpdateRunnerInfo(runner.Id, *req.Info, req.Tags, t)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	candidates := []int64{}
+	for _, tag := range req.Tags {
+		limit := int64(req.Limit - len(candidates))
+		if limit > 0 {
+			jobIds, err := FindJobsForRunner(tag, runner.ProjectId, compatibilityMode(int64(req.Protocol)), limit, t)
+			if err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			candidates = append(candidates, jobIds...)
+		}
+	}
+	jobs := []api.RunnerResponseJob{}
+	for _, candidate := range candidates {
+		pass, hash, err := GenerateRandom(PrefixJob)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		jobObj, err := ReserveJobForRunner(candidate, hash, runner.Id, t)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		go func() {
+			notifyJobStatus(jobObj.Id)
+			UpdateEntityStatus(jobObj.EntityId)
+		}()
+		entity, err := LoadEntity(jobObj.EntityId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		project, err := LoadProject(entity.ProjectId)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		var cache *api.JobCache
+		if jobObj.Cache != "" {
+			cache = &api.JobCache{}
+			err = json.Unmarshal([]byte(jobObj.Cache), cache)
+			if err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
+		var limits *api.JobLimits
+		if jobObj.Limits != "" {
+			limits = &api.JobLimits{}
+			err = json.Unmarshal([]byte(jobObj.Limits), limits)
+			if err != nil {
+				log.Println(err)
+				respondError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
 		job := api.RunnerResponseJob{
 			Id:        jobObj.Id,
-			Project:   project.Name,
+			Project:   project.Slug,
 			EntityKey: entity.Key,
 			EntityVal: entity.Val,
 			Name:      jobObj.Name,
@@ -265,6 +1125,8 @@ func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
 			Cmd:       jobObj.Cmd,
 			Env:       jobObj.Env,
 			Tag:       jobObj.Tag,
+			Cache:     cache,
+			Limits:    limits,
 		}
 		jobs = append(jobs, job)
 		if len(jobs) >= req.Limit {
@@ -275,7 +1137,7 @@ func RouteApiRunner(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, api.RunnerResponse{Jobs: jobs})
 }
 
-var allowedStorageRegex = regexp.MustCompile(`^\d+/log$`)
+var allowedStorageRegex = regexp.MustCompile(`^\d+/(log|log\.jsonl)$`)
 
 func RouteApiStorage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -323,7 +1185,7 @@ func RouteApiStorage(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
-		authOk, err = checkRunnerAuth(runner.Auth, authHeader)
+		authOk, err = checkRunnerAuth(runner.Id, authHeader)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, "internal server error")
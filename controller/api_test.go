@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteApiRegister(t *testing.T) {
+	project := setupTestDatabase(t)
+	pass, hash, err := GenerateRandom(PrefixRegistration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateRegistrationToken("once", hash, project.Id, true, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateRunner("buildbox", []byte{}, 0, 0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected int
+	}{
+		{"buildbox", http.StatusConflict}, // does not use up the token
+		{"buildbox-linux", http.StatusOK},
+		{"buildbox-windows", http.StatusUnauthorized}, // the token was single use
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/register", bytes.NewReader([]byte(`{"name": "`+test.name+`"}`)))
+		r.Header.Set("Authorization", "Bearer "+pass)
+		w := httptest.NewRecorder()
+		RouteApiRegister(w, r)
+		if w.Code != test.expected {
+			t.Errorf("registering %s returned %d, want %d: %s", test.name, w.Code, test.expected, w.Body.String())
+		}
+	}
+}
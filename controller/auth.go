@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 
 	"golang.org/x/crypto/bcrypt"
@@ -11,10 +15,18 @@ import (
 const PrefixAdmin = "AURA_ADMINKEY_"
 const PrefixJob = "AURA_JOBKEY_"
 const PrefixProject = "AURA_PROJECTKEY_"
+const PrefixRegistration = "AURA_REGISTRATIONKEY_"
 const PrefixRunner = "AURA_RUNNERKEY_"
 
+// hashPrefixSha256 starts the SHA-256 hashes of keys, hashes starting with "$2" are bcrypt hashes from before
+const hashPrefixSha256 = "sha256:"
+
+// GenerateFromPassword hashes a key with SHA-256.
+// All keys are generated randomly with 252 bits of entropy, so unlike passwords they can not be guessed
+// and a single fast hash protects them as well as bcrypt, without costing CPU time on every request.
 func GenerateFromPassword(pass string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	sum := sha256.Sum256([]byte(pass))
+	return []byte(hashPrefixSha256 + hex.EncodeToString(sum[:])), nil
 }
 
 func GenerateRandom(prefix string) (string, []byte, error) {
@@ -33,6 +45,13 @@ func GenerateRandom(prefix string) (string, []byte, error) {
 }
 
 func CompareHashAndPassword(hash []byte, pass string) (bool, error) {
+	if bytes.HasPrefix(hash, []byte(hashPrefixSha256)) {
+		expected, err := GenerateFromPassword(pass)
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+	}
 	err := bcrypt.CompareHashAndPassword(hash, []byte(pass))
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
@@ -42,3 +61,8 @@ func CompareHashAndPassword(hash []byte, pass string) (bool, error) {
 	}
 	return true, nil
 }
+
+// isLegacyHash reports whether the hash is a bcrypt hash, which is replaced once the key is used successfully
+func isLegacyHash(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2"))
+}
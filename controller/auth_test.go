@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCompareHashAndPassword(t *testing.T) {
+	pass, hash, err := GenerateRandom(PrefixRunner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		hash     []byte
+		pass     string
+		expected bool
+	}{
+		{"sha256", hash, pass, true},
+		{"sha256 mismatch", hash, pass + "x", false},
+		{"bcrypt", legacyHash, pass, true},
+		{"bcrypt mismatch", legacyHash, pass + "x", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authOk, err := CompareHashAndPassword(test.hash, test.pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if authOk != test.expected {
+				t.Errorf("got %v, want %v", authOk, test.expected)
+			}
+		})
+	}
+}
+
+func TestCheckKeysUpgradesLegacyHash(t *testing.T) {
+	project := setupTestDatabase(t)
+	now := time.Now()
+	pass := PrefixProject + "colors-00000000000000000000000000000000000"
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := RotateKey(KeyKindProject, project.Id, legacyHash, now, time.Unix(0, 0), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		authOk, err := checkKeys(KeyKindProject, project.Id, pass, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !authOk {
+			t.Fatalf("check %d failed", i)
+		}
+		key, err := LoadKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(key.Auth, []byte(hashPrefixSha256)) {
+			t.Fatalf("hash was not upgraded after check %d: %s", i, key.Auth)
+		}
+	}
+}
+
+func BenchmarkCompareHashAndPassword(b *testing.B) {
+	pass, hash, err := GenerateRandom(PrefixRunner)
+	if err != nil {
+		b.Fatal(err)
+	}
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("bcrypt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CompareHashAndPassword(legacyHash, pass)
+		}
+	})
+	b.Run("sha256", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CompareHashAndPassword(hash, pass)
+		}
+	})
+}
+
+// BenchmarkCheckRunnerAuth measures the whole check a runner request goes through, including the database
+func BenchmarkCheckRunnerAuth(b *testing.B) {
+	setupTestDatabase(b)
+	pass, hash, err := GenerateRandom(PrefixRunner)
+	if err != nil {
+		b.Fatal(err)
+	}
+	runnerId, err := CreateRunner("buildbox", hash, 0, 0, time.Now())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		authOk, err := checkRunnerAuth(runnerId, pass)
+		if err != nil || !authOk {
+			b.Fatal("check failed", err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cacheMutex serializes changes to the cache so that the size accounting stays consistent
+var cacheMutex sync.Mutex
+
+func cacheEntryPath(id int64) string {
+	return filepath.Join("cache", fmt.Sprintf("%d", id))
+}
+
+// SaveCacheEntry stores the content of r as the cache entry for key and evicts old entries if necessary.
+// Returns errCacheEntryTooLarge if the content is larger than allowed.
+func SaveCacheEntry(projectId int64, key string, r io.Reader, now time.Time) error {
+	err := os.MkdirAll("cache", os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("cache", "upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	size, err := io.Copy(file, io.LimitReader(r, config.Cache.MaxEntrySize+1))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	if size > config.Cache.MaxEntrySize {
+		return errCacheEntryTooLarge
+	}
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	oldEntry, err := FindCacheEntry(projectId, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	id, err := CreateCacheEntry(projectId, key, size, now)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(file.Name(), cacheEntryPath(id))
+	if err != nil {
+		return err
+	}
+	if oldEntry.Id > 0 {
+		err = deleteCacheEntry(oldEntry.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return evictCacheEntries(id)
+}
+
+var errCacheEntryTooLarge = errors.New("cache entry too large")
+
+// evictCacheEntries deletes the least recently used entries until the cache fits into its maximum size again.
+// The entry with the id keep is never evicted.
+func evictCacheEntries(keep int64) error {
+	entries, err := FindCacheEntriesByLastUsed()
+	if err != nil {
+		return err
+	}
+	total := int64(0)
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	for _, entry := range entries {
+		if total <= config.Cache.MaxSize {
+			break
+		}
+		if entry.Id == keep {
+			continue
+		}
+		log.Printf("Evicting cache entry %s of project %d...", entry.Key, entry.ProjectId)
+		err = deleteCacheEntry(entry.Id)
+		if err != nil {
+			return err
+		}
+		total -= entry.Size
+	}
+	return nil
+}
+
+func deleteCacheEntry(id int64) error {
+	err := DeleteCacheEntry(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(cacheEntryPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// FindCacheEntryForRestore returns the entry for key or, if there is none, the newest entry matching one of the restore keys
+func FindCacheEntryForRestore(projectId int64, key string, restoreKeys []string) (CacheEntry, error) {
+	entry, err := FindCacheEntry(projectId, key)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return entry, err
+	}
+	for _, restoreKey := range restoreKeys {
+		entry, err = FindCacheEntryByPrefix(projectId, restoreKey)
+		if err == nil || !errors.Is(err, ErrNotFound) {
+			return entry, err
+		}
+	}
+	return CacheEntry{}, ErrNotFound
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+)
+
+type GeneralConfig struct {
+	BaseUrl string `json:"baseUrl"`
+
+	Cache CacheConfig `json:"cache"`
+
+	Email EmailConfig `json:"email"`
+
+	Runners RunnersConfig `json:"runners"`
+}
+
+type CacheConfig struct {
+	// Maximum size in bytes of all cache entries combined
+	MaxSize int64 `json:"maxSize"`
+
+	// Maximum size in bytes of a single cache entry
+	MaxEntrySize int64 `json:"maxEntrySize"`
+}
+
+type EmailConfig struct {
+	// SMTP server as host:port, email notifications are disabled if empty
+	Server string `json:"server"`
+
+	// Credentials for the SMTP server, leave empty to send without authentication
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Sender address of the notifications
+	From string `json:"from"`
+
+	// Notification settings for each project, keyed by project slug
+	Projects map[string]EmailProjectConfig `json:"projects"`
+}
+
+type EmailProjectConfig struct {
+	// Addresses notified about every watched collection of the project
+	Recipients []string `json:"recipients"`
+
+	// Watched collections as key/val, e.g. ref/main, with addresses notified only about that collection
+	Collections map[string][]string `json:"collections"`
+
+	// Seconds to collect notifications for before sending them as a single digest, 0 sends them right away
+	Digest int64 `json:"digest"`
+}
+
+type RunnersConfig struct {
+	// Free disk space in bytes below which a runner is flagged on the runners page
+	LowDiskSpace int64 `json:"lowDiskSpace"`
+
+	// Seconds since the last check-in for which a runner or tag is considered online
+	OnlineThreshold int64 `json:"onlineThreshold"`
+
+	// Seconds since the last check-in after which a runner or tag is considered offline instead of stale
+	OfflineThreshold int64 `json:"offlineThreshold"`
+}
+
+var config GeneralConfig
+
+// integrationConfigs contains the configuration for every integration, keyed by its ID
+var integrationConfigs map[string]json.RawMessage
+
+// generalConfigKeys are the keys in config.json that do not configure an integration
+var generalConfigKeys = map[string]bool{
+	"baseUrl": true,
+	"cache":   true,
+	"email":   true,
+	"runners": true,
+}
+
+func LoadConfig() {
+	config = GeneralConfig{
+		Cache: CacheConfig{
+			MaxSize:      10 * 1024 * 1024 * 1024,
+			MaxEntrySize: 1024 * 1024 * 1024,
+		},
+		Runners: RunnersConfig{
+			LowDiskSpace:     5 * 1024 * 1024 * 1024,
+			OnlineThreshold:  3 * 60,
+			OfflineThreshold: 60 * 60,
+		},
+	}
+	cfg := map[string]json.RawMessage{}
+	integrationConfigs = map[string]json.RawMessage{}
+
+	_, err := os.Stat("config.json")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Fatalln(err)
+		}
+	} else {
+		data, err := os.ReadFile("config.json")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		err = json.Unmarshal(data, &cfg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	baseUrl, err := url.Parse(config.BaseUrl)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	config.BaseUrl = fmt.Sprintf("%s://%s", baseUrl.Scheme, baseUrl.Host)
+
+	for k, v := range cfg {
+		if !generalConfigKeys[k] {
+			integrationConfigs[k] = v
+		}
+	}
+}
@@ -3,12 +3,19 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"sort"
+	"strings"
 	"time"
+
+	"github.com/unnamedtiger/aura/api"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 var ErrNotFound = errors.New("not found")
+var ErrExists = errors.New("already exists")
 
 var db *sql.DB
 
@@ -26,25 +33,29 @@ const (
 func jobStatus(status int) string {
 	switch status {
 	case StatusCreated:
-		return "created"
+		return api.JobStatusCreated
 	case StatusStarted:
-		return "started"
+		return api.JobStatusStarted
 	case StatusCancelled:
-		return "cancelled"
+		return api.JobStatusCancelled
 	case StatusSucceeded:
-		return "succeeded"
+		return api.JobStatusSucceeded
 	case StatusFailed:
-		return "failed"
+		return api.JobStatusFailed
 	case StatusSubmitted:
-		return "submitted"
+		return api.JobStatusSubmitted
 	default:
 		return "unknown"
 	}
 }
 
-type Admin struct {
-	Id   int64
-	Auth []byte
+type CacheEntry struct {
+	Id        int64
+	ProjectId int64
+	Key       string
+	Size      int64
+	Created   time.Time
+	LastUsed  time.Time
 }
 
 type EntityOrCollection struct {
@@ -70,19 +81,171 @@ type Job struct {
 	Tag           string
 	Runner        int64
 	ExitCode      int64
+	Cache         string
+	Limits        string
+	Message       string
+}
+
+// Values of Key.Kind
+const (
+	KeyKindAdmin   = "admin"
+	KeyKindProject = "project"
+	KeyKindRunner  = "runner"
+)
+
+// Key is an admin, project or runner key, several keys of the same owner work at the same time while a key is rotated
+type Key struct {
+	Id       int64
+	Kind     string
+	OwnerId  int64 // project or runner, 0 for admin keys
+	Auth     []byte
+	Created  time.Time
+	Expires  time.Time
+	Revoked  time.Time
+	LastUsed time.Time
+}
+
+// Usable reports whether the key is neither revoked nor expired
+func (k Key) Usable(now time.Time) bool {
+	if k.Revoked.Unix() != 0 {
+		return false
+	}
+	return k.Expires.Unix() == 0 || k.Expires.After(now)
 }
 
 type Project struct {
 	Id   int64
 	Name string
 	Slug string
-	Auth []byte
+}
+
+type RegistrationToken struct {
+	Id        int64
+	Name      string
+	Auth      []byte
+	ProjectId int64
+	SingleUse bool
+	Expires   time.Time
+	Uses      int64
+	Created   time.Time
+}
+
+// Usable reports whether a runner may still be registered with this token
+func (t RegistrationToken) Usable(now time.Time) bool {
+	if t.SingleUse && t.Uses > 0 {
+		return false
+	}
+	return t.Expires.Unix() == 0 || t.Expires.After(now)
+}
+
+type QueuedTag struct {
+	Tag    string
+	Count  int64
+	Oldest time.Time
 }
 
 type Runner struct {
-	Id   int64
-	Name string
-	Auth []byte
+	Id                  int64
+	Name                string
+	Version             string
+	Os                  string
+	Arch                string
+	Cpus                int64
+	Memory              int64
+	FreeDisk            int64
+	Hostname            string
+	Tags                []string
+	InfoUpdated         time.Time
+	ProjectId           int64
+	RegistrationTokenId int64
+	Protocol            int64
+}
+
+// StatusUpdate is a pending or failed commit status update of an integration for an entity
+type StatusUpdate struct {
+	Id          int64
+	Integration string
+	EntityId    int64
+	Revision    int64     // incremented whenever the update is queued again, so that deliveries do not drop newer updates
+	Attempts    int64     // failed attempts since it was last queued
+	Due         time.Time // zero once the update was given up
+	Updated     time.Time
+	LastAttempt time.Time
+	Error       string
+}
+
+type Webhook struct {
+	Id        int64
+	ProjectId int64
+	Url       string
+	Secret    string
+	Events    []string
+	Created   time.Time
+}
+
+// Wants reports whether the webhook is subscribed to the event
+func (w Webhook) Wants(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	Id         int64
+	WebhookId  int64
+	Url        string
+	Event      string
+	Created    time.Time
+	StatusCode int64
+	Error      string
+	Duration   time.Duration
+}
+
+func CountJobsByRunner(runnerId int64) (map[int]int64, error) {
+	rows, err := db.Query("SELECT status, COUNT(*) FROM jobs WHERE runner = ? GROUP BY status", runnerId)
+	if err != nil {
+		return nil, err
+	}
+	results := map[int]int64{}
+	for rows.Next() {
+		var status int
+		var count int64
+		err := rows.Scan(&status, &count)
+		if err != nil {
+			return nil, err
+		}
+		results[status] = count
+	}
+	return results, nil
+}
+
+func CountStartedJobsPerRunner() (map[int64]int64, error) {
+	rows, err := db.Query("SELECT runner, COUNT(*) FROM jobs WHERE status = ? AND runner IS NOT NULL GROUP BY runner", StatusStarted)
+	if err != nil {
+		return nil, err
+	}
+	results := map[int64]int64{}
+	for rows.Next() {
+		var runnerId int64
+		var count int64
+		err := rows.Scan(&runnerId, &count)
+		if err != nil {
+			return nil, err
+		}
+		results[runnerId] = count
+	}
+	return results, nil
+}
+
+func CreateCacheEntry(projectId int64, key string, size int64, created time.Time) (int64, error) {
+	res, err := db.Exec("INSERT INTO caches (id, projectId, key, size, created, lastUsed) VALUES (NULL, ?, ?, ?, ?, ?)", projectId, key, size, created.Unix(), created.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
 }
 
 func CreateCollection(projectId int64, key string, val string, created time.Time) error {
@@ -95,8 +258,8 @@ func CreateEntity(projectId int64, key string, val string, created time.Time) er
 	return err
 }
 
-func CreateJob(entityId int64, name string, created time.Time, earliestStart time.Time, cmd string, env string, tag string) (int64, error) {
-	res, err := db.Exec("INSERT INTO jobs (id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode) VALUES (NULL, ?, ?, ?, ?, ?, NULL, NULL, NULL, ?, ?, ?, NULL, 0)", entityId, name, StatusSubmitted, created.Unix(), earliestStart.Unix(), cmd, env, tag)
+func CreateJob(entityId int64, name string, created time.Time, earliestStart time.Time, cmd string, env string, tag string, cache string, limits string) (int64, error) {
+	res, err := db.Exec("INSERT INTO jobs (id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode, cache, limits) VALUES (NULL, ?, ?, ?, ?, ?, NULL, NULL, NULL, ?, ?, ?, NULL, 0, ?, ?)", entityId, name, StatusSubmitted, created.Unix(), earliestStart.Unix(), cmd, env, tag, nullString(cache), nullString(limits))
 	if err != nil {
 		return 0, err
 	}
@@ -108,22 +271,179 @@ func CreatePrecedingJob(olderJob int64, newerJob int64) error {
 	return err
 }
 
-func CreateProject(name string, slug string, auth []byte) (int64, error) {
-	res, err := db.Exec("INSERT INTO projects (id, name, slug, auth) VALUES (NULL, ?, ?, ?)", name, slug, auth)
+// CreateProject creates the project together with its first key
+func CreateProject(name string, slug string, auth []byte, created time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO projects (id, name, slug) VALUES (NULL, ?, ?)", name, slug)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, ?, ?, ?, ?)", KeyKindProject, id, auth, created.Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func CreateRegistrationToken(name string, auth []byte, projectId int64, singleUse bool, expires time.Time, created time.Time) (int64, error) {
+	expiresTimestamp := sql.NullInt64{}
+	if !expires.IsZero() {
+		expiresTimestamp = sql.NullInt64{Int64: expires.Unix(), Valid: true}
+	}
+	res, err := db.Exec("INSERT INTO registrationTokens (id, name, auth, projectId, singleUse, expires, uses, created) VALUES (NULL, ?, ?, ?, ?, ?, 0, ?)", name, auth, nullInt64(projectId), singleUse, expiresTimestamp, created.Unix())
 	if err != nil {
 		return 0, err
 	}
 	return res.LastInsertId()
 }
 
-func CreateRunner(name string, auth []byte) (int64, error) {
-	res, err := db.Exec("INSERT INTO runners (id, name, auth) VALUES (NULL, ?, ?)", name, auth)
+// CreateRunner creates the runner together with its first key and counts the registration with the token.
+// Returns ErrExists if a runner with the name exists and ErrNotFound if the token has been used up or expired in the meantime.
+func CreateRunner(name string, auth []byte, projectId int64, registrationTokenId int64, created time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if registrationTokenId > 0 {
+		res, err := tx.Exec("UPDATE registrationTokens SET uses = uses + 1 WHERE id = ? AND (singleUse = 0 OR uses = 0) AND (expires IS NULL OR expires > ?)", registrationTokenId, created.Unix())
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if rows != 1 {
+			tx.Rollback()
+			return 0, ErrNotFound
+		}
+	}
+	res, err := tx.Exec("INSERT INTO runners (id, name, projectId, registrationTokenId) VALUES (NULL, ?, ?, ?)", name, nullInt64(projectId), nullInt64(registrationTokenId))
+	if err != nil {
+		tx.Rollback()
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return 0, ErrExists
+		}
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, ?, ?, ?, ?)", KeyKindRunner, id, auth, created.Unix())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// CreateStatusUpdate queues a status update, an already queued update for the entity is due again instead
+func CreateStatusUpdate(integration string, entityId int64, now time.Time) error {
+	_, err := db.Exec("INSERT INTO statusOutbox (id, integration, entityId, revision, attempts, due, updated, lastAttempt, error) VALUES (NULL, ?, ?, 1, 0, ?, ?, NULL, NULL) ON CONFLICT (integration, entityId) DO UPDATE SET revision = revision + 1, attempts = 0, due = excluded.due, updated = excluded.updated", integration, entityId, now.Unix(), now.Unix())
+	return err
+}
+
+func CreateWebhook(projectId int64, url string, secret string, events []string, created time.Time) (int64, error) {
+	res, err := db.Exec("INSERT INTO webhooks (id, projectId, url, secret, events, created) VALUES (NULL, ?, ?, ?, ?, ?)", projectId, url, secret, strings.Join(events, ","), created.Unix())
 	if err != nil {
 		return 0, err
 	}
 	return res.LastInsertId()
 }
 
+// CreateWebhookDelivery logs a delivery and only keeps the most recent deliveries of the webhook
+func CreateWebhookDelivery(webhookId int64, event string, created time.Time, statusCode int, errorMessage string, duration time.Duration) error {
+	_, err := db.Exec("INSERT INTO webhookDeliveries (id, webhookId, event, created, statusCode, error, duration) VALUES (NULL, ?, ?, ?, ?, ?, ?)", webhookId, event, created.Unix(), nullInt64(int64(statusCode)), nullString(errorMessage), duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM webhookDeliveries WHERE webhookId = ? AND id NOT IN (SELECT id FROM webhookDeliveries WHERE webhookId = ? ORDER BY id DESC LIMIT 100)", webhookId, webhookId)
+	return err
+}
+
+func DeleteCacheEntry(id int64) error {
+	_, err := db.Exec("DELETE FROM caches WHERE id = ?", id)
+	return err
+}
+
+// DeleteStatusUpdate removes a delivered status update unless it was queued again in the meantime
+func DeleteStatusUpdate(id int64, revision int64) error {
+	_, err := db.Exec("DELETE FROM statusOutbox WHERE id = ? AND revision = ?", id, revision)
+	return err
+}
+
+func DeleteWebhook(id int64) error {
+	_, err := db.Exec("DELETE FROM webhookDeliveries WHERE webhookId = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	return err
+}
+
+func FindCacheEntry(projectId int64, key string) (CacheEntry, error) {
+	rows, err := db.Query("SELECT id, projectId, key, size, created, lastUsed FROM caches WHERE projectId = ? AND key = ? ORDER BY created DESC LIMIT 1", projectId, key)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	if rows.Next() {
+		entry, err := ScanCacheEntry(rows)
+		rows.Close()
+		if err != nil {
+			return CacheEntry{}, err
+		}
+		return entry, nil
+	}
+	return CacheEntry{}, ErrNotFound
+}
+
+func FindCacheEntryByPrefix(projectId int64, prefix string) (CacheEntry, error) {
+	rows, err := db.Query("SELECT id, projectId, key, size, created, lastUsed FROM caches WHERE projectId = ? AND substr(key, 1, ?) = ? ORDER BY created DESC LIMIT 1", projectId, len(prefix), prefix)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	if rows.Next() {
+		entry, err := ScanCacheEntry(rows)
+		rows.Close()
+		if err != nil {
+			return CacheEntry{}, err
+		}
+		return entry, nil
+	}
+	return CacheEntry{}, ErrNotFound
+}
+
+func FindCacheEntriesByLastUsed() ([]CacheEntry, error) {
+	rows, err := db.Query("SELECT id, projectId, key, size, created, lastUsed FROM caches ORDER BY lastUsed ASC")
+	if err != nil {
+		return nil, err
+	}
+	results := []CacheEntry{}
+	for rows.Next() {
+		entry, err := ScanCacheEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, entry)
+	}
+	return results, nil
+}
+
 func FindCollection(projectId int64, key string, val string) (EntityOrCollection, error) {
 	return findEntityOrCollection("collections", projectId, key, val)
 }
@@ -145,6 +465,22 @@ func FindCollectionKeysByProjectId(projectId int64) ([]string, error) {
 	return results, nil
 }
 
+func FindCollectionsOfEntity(entityId int64) ([]EntityOrCollection, error) {
+	rows, err := db.Query("SELECT collections.id, collections.projectId, collections.key, collections.val, collections.created FROM collections INNER JOIN collectionsEntities on collections.id = collectionsEntities.collectionId WHERE collectionsEntities.entityId = ? ORDER BY collections.key ASC, collections.val ASC", entityId)
+	if err != nil {
+		return nil, err
+	}
+	results := []EntityOrCollection{}
+	for rows.Next() {
+		collection, err := ScanEntityOrCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, collection)
+	}
+	return results, nil
+}
+
 func FindEntitiesInCollection(collectionId int64, before int64, limit int64) ([]EntityOrCollection, error) {
 	query := "SELECT entities.id, entities.projectId, entities.key, entities.val, entities.created FROM entities INNER JOIN collectionsEntities on entities.id = collectionsEntities.entityId WHERE collectionsEntities.collectionId = ? "
 	args := []any{collectionId}
@@ -193,19 +529,21 @@ func findEntitiesOrCollections(table string, projectId int64, key string, before
 	return results, nil
 }
 
-func FindEntitiesOrCollections(projectId int64, key string, before int64, limit int64) ([]EntityOrCollection, error) {
+// FindEntitiesOrCollections returns the entities with the key or, if there are none, the collections with the key.
+// Also reports whether collections were returned.
+func FindEntitiesOrCollections(projectId int64, key string, before int64, limit int64) ([]EntityOrCollection, bool, error) {
 	entities, err := findEntitiesOrCollections("entities", projectId, key, before, limit)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if len(entities) > 0 {
-		return entities, nil
+		return entities, false, nil
 	}
 	collections, err := findEntitiesOrCollections("collections", projectId, key, before, limit)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return collections, nil
+	return collections, len(collections) > 0, nil
 }
 
 func FindEntity(projectId int64, key string, val string) (EntityOrCollection, error) {
@@ -274,8 +612,42 @@ func FindEntityOrCollectionKeysByProjectId(projectId int64) ([]string, error) {
 	return keys, nil
 }
 
+// FindDueStatusUpdates returns the status updates of the integration that are due, the longest waiting ones first
+func FindDueStatusUpdates(integration string, now time.Time, limit int64) ([]StatusUpdate, error) {
+	rows, err := db.Query("SELECT id, integration, entityId, revision, attempts, due, updated, lastAttempt, error FROM statusOutbox WHERE integration = ? AND due IS NOT NULL AND due <= ? ORDER BY due ASC LIMIT ?", integration, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	results := []StatusUpdate{}
+	for rows.Next() {
+		update, err := ScanStatusUpdate(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, update)
+	}
+	return results, nil
+}
+
+// FindFailedStatusUpdates returns the status updates that failed at least once, given up ones first
+func FindFailedStatusUpdates(limit int64) ([]StatusUpdate, error) {
+	rows, err := db.Query("SELECT id, integration, entityId, revision, attempts, due, updated, lastAttempt, error FROM statusOutbox WHERE attempts > 0 ORDER BY due IS NOT NULL, lastAttempt DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	results := []StatusUpdate{}
+	for rows.Next() {
+		update, err := ScanStatusUpdate(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, update)
+	}
+	return results, nil
+}
+
 func FindJobs(entityId int64) ([]Job, error) {
-	rows, err := db.Query("SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode FROM jobs WHERE entityId = ? ORDER BY created ASC", entityId)
+	rows, err := db.Query("SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode, cache, limits, message FROM jobs WHERE entityId = ? ORDER BY created ASC", entityId)
 	if err != nil {
 		return nil, err
 	}
@@ -290,8 +662,46 @@ func FindJobs(entityId int64) ([]Job, error) {
 	return results, nil
 }
 
-func FindJobsForRunner(tag string, limit int64, now time.Time) ([]int64, error) {
-	rows, err := db.Query("SELECT DISTINCT jobs.id FROM jobs LEFT JOIN precedingJobs ON jobs.id = precedingJobs.newerJob WHERE precedingJobs.newerJob IS NULL AND jobs.tag = ? AND jobs.status = ? AND jobs.earliestStart <= ? ORDER BY jobs.created ASC LIMIT ?", tag, StatusCreated, now.Unix(), limit)
+// FindJobsByRunner finds the jobs a runner has finished, most recently started first
+func FindJobsByRunner(runnerId int64, before int64, limit int64) ([]Job, error) {
+	query := "SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode, cache, limits, message FROM jobs WHERE runner = ? AND status != ? "
+	args := []any{runnerId, StatusStarted}
+	if before > 0 {
+		query += "AND started < ? "
+		args = append(args, before)
+	}
+	query += "ORDER BY started DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	results := []Job{}
+	for rows.Next() {
+		job, err := ScanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, job)
+	}
+	return results, nil
+}
+
+// FindJobsForRunner returns the ids of the jobs a runner may start next.
+// Runners in compatibility mode only get jobs without a cache and limits.
+func FindJobsForRunner(tag string, projectId int64, compatibilityMode bool, limit int64, now time.Time) ([]int64, error) {
+	query := "SELECT DISTINCT jobs.id FROM jobs LEFT JOIN precedingJobs ON jobs.id = precedingJobs.newerJob WHERE precedingJobs.newerJob IS NULL AND jobs.tag = ? AND jobs.status = ? AND jobs.earliestStart <= ? "
+	args := []any{tag, StatusCreated, now.Unix()}
+	if projectId > 0 {
+		query += "AND jobs.entityId IN (SELECT id FROM entities WHERE projectId = ?) "
+		args = append(args, projectId)
+	}
+	if compatibilityMode {
+		query += "AND jobs.cache IS NULL AND jobs.limits IS NULL "
+	}
+	query += "ORDER BY jobs.created ASC LIMIT ?"
+	args = append(args, limit)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -307,8 +717,25 @@ func FindJobsForRunner(tag string, limit int64, now time.Time) ([]int64, error)
 	return results, nil
 }
 
+// FindKeys returns all keys of the owner including revoked and expired ones, newest first
+func FindKeys(kind string, ownerId int64) ([]Key, error) {
+	rows, err := db.Query("SELECT id, kind, ownerId, auth, created, expires, revoked, lastUsed FROM keys WHERE kind = ? AND IFNULL(ownerId, 0) = ? ORDER BY id DESC", kind, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	results := []Key{}
+	for rows.Next() {
+		key, err := ScanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, key)
+	}
+	return results, nil
+}
+
 func FindPrecedingJobs(id int64) ([]Job, error) {
-	rows, err := db.Query("SELECT jobs.id, jobs.entityId, jobs.name, jobs.status, jobs.created, jobs.earliestStart, jobs.started, jobs.ended, jobs.auth, jobs.cmd, jobs.env, jobs.tag, jobs.runner, jobs.exitCode FROM precedingJobs INNER JOIN jobs ON precedingJobs.olderJob = jobs.id WHERE precedingJobs.newerJob = ?", id)
+	rows, err := db.Query("SELECT jobs.id, jobs.entityId, jobs.name, jobs.status, jobs.created, jobs.earliestStart, jobs.started, jobs.ended, jobs.auth, jobs.cmd, jobs.env, jobs.tag, jobs.runner, jobs.exitCode, jobs.cache, jobs.limits, jobs.message FROM precedingJobs INNER JOIN jobs ON precedingJobs.olderJob = jobs.id WHERE precedingJobs.newerJob = ?", id)
 	if err != nil {
 		return nil, err
 	}
@@ -324,7 +751,7 @@ func FindPrecedingJobs(id int64) ([]Job, error) {
 }
 
 func FindProjectBySlug(slug string) (Project, error) {
-	rows, err := db.Query("SELECT id, name, slug, auth FROM projects WHERE slug = ?", slug)
+	rows, err := db.Query("SELECT id, name, slug FROM projects WHERE slug = ?", slug)
 	if err != nil {
 		return Project{}, err
 	}
@@ -339,9 +766,9 @@ func FindProjectBySlug(slug string) (Project, error) {
 	return Project{}, ErrNotFound
 }
 
-func FindQueuedJobs(before int64, limit int64) ([]Job, error) {
-	query := "SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode FROM jobs WHERE (status = ? OR status = ?) "
-	args := []any{StatusSubmitted, StatusCreated}
+func FindQueuedJobs(tag string, before int64, limit int64) ([]Job, error) {
+	query := "SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode, cache, limits, message FROM jobs WHERE (status = ? OR status = ?) AND tag = ? "
+	args := []any{StatusSubmitted, StatusCreated, tag}
 	if before > 0 {
 		query += "AND created < ? "
 		args = append(args, before)
@@ -363,8 +790,40 @@ func FindQueuedJobs(before int64, limit int64) ([]Job, error) {
 	return results, nil
 }
 
+// FindQueuePosition returns the position of a queued job among the queued jobs with the same tag, starting at 1,
+// and the number of queued jobs with that tag
+func FindQueuePosition(job Job) (int64, int64, error) {
+	var ahead int64
+	var length int64
+	err := db.QueryRow("SELECT COUNT(CASE WHEN created < ? OR (created = ? AND id < ?) THEN 1 END), COUNT(*) FROM jobs WHERE tag = ? AND (status = ? OR status = ?)", job.Created.Unix(), job.Created.Unix(), job.Id, job.Tag, StatusSubmitted, StatusCreated).Scan(&ahead, &length)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ahead + 1, length, nil
+}
+
+// FindQueuedTags returns the number of queued jobs and the creation time of the oldest one for every tag
+func FindQueuedTags() ([]QueuedTag, error) {
+	rows, err := db.Query("SELECT tag, COUNT(*), MIN(created) FROM jobs WHERE status = ? OR status = ? GROUP BY tag ORDER BY tag ASC", StatusSubmitted, StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	results := []QueuedTag{}
+	for rows.Next() {
+		var tag string
+		var count int64
+		var oldestTimestamp int64
+		err := rows.Scan(&tag, &count, &oldestTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, QueuedTag{Tag: tag, Count: count, Oldest: time.Unix(oldestTimestamp, 0)})
+	}
+	return results, nil
+}
+
 func FindRunnerByName(name string) (Runner, error) {
-	rows, err := db.Query("SELECT id, name, auth FROM runners WHERE name = ?", name)
+	rows, err := db.Query("SELECT id, name, version, os, arch, cpus, memory, freeDisk, hostname, tags, infoUpdated, projectId, registrationTokenId, protocol FROM runners WHERE name = ?", name)
 	if err != nil {
 		return Runner{}, err
 	}
@@ -379,6 +838,22 @@ func FindRunnerByName(name string) (Runner, error) {
 	return Runner{}, ErrNotFound
 }
 
+func FindStartedJobsByRunner(runnerId int64) ([]Job, error) {
+	rows, err := db.Query("SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode, cache, limits, message FROM jobs WHERE runner = ? AND status = ? ORDER BY started ASC", runnerId, StatusStarted)
+	if err != nil {
+		return nil, err
+	}
+	results := []Job{}
+	for rows.Next() {
+		job, err := ScanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, job)
+	}
+	return results, nil
+}
+
 func FindSuccedingJobIds(id int64) ([]int64, error) {
 	rows, err := db.Query("SELECT newerJob FROM precedingJobs WHERE olderJob = ?", id)
 	if err != nil {
@@ -396,6 +871,38 @@ func FindSuccedingJobIds(id int64) ([]int64, error) {
 	return results, nil
 }
 
+func FindWebhookDeliveriesByProjectId(projectId int64, limit int64) ([]WebhookDelivery, error) {
+	rows, err := db.Query("SELECT webhookDeliveries.id, webhookDeliveries.webhookId, webhooks.url, webhookDeliveries.event, webhookDeliveries.created, webhookDeliveries.statusCode, webhookDeliveries.error, webhookDeliveries.duration FROM webhookDeliveries INNER JOIN webhooks ON webhookDeliveries.webhookId = webhooks.id WHERE webhooks.projectId = ? ORDER BY webhookDeliveries.id DESC LIMIT ?", projectId, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := ScanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, delivery)
+	}
+	return results, nil
+}
+
+func FindWebhooksByProjectId(projectId int64) ([]Webhook, error) {
+	rows, err := db.Query("SELECT id, projectId, url, secret, events, created FROM webhooks WHERE projectId = ? ORDER BY id ASC", projectId)
+	if err != nil {
+		return nil, err
+	}
+	results := []Webhook{}
+	for rows.Next() {
+		webhook, err := ScanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, webhook)
+	}
+	return results, nil
+}
+
 func InsertEntityIntoCollection(collectionId int64, entityId int64) error {
 	rows, err := db.Query("SELECT id, collectionId, entityId FROM collectionsEntities WHERE collectionId = ? AND entityId = ?", collectionId, entityId)
 	if err != nil {
@@ -409,22 +916,6 @@ func InsertEntityIntoCollection(collectionId int64, entityId int64) error {
 	return err
 }
 
-func LoadAdmin() (Admin, error) {
-	rows, err := db.Query("SELECT id, auth FROM admins")
-	if err != nil {
-		return Admin{}, err
-	}
-	if rows.Next() {
-		admin, err := ScanAdmin(rows)
-		rows.Close()
-		if err != nil {
-			return Admin{}, err
-		}
-		return admin, nil
-	}
-	return Admin{}, ErrNotFound
-}
-
 func LoadEntity(id int64) (EntityOrCollection, error) {
 	rows, err := db.Query("SELECT id, projectId, key, val, created FROM entities WHERE id = ?", id)
 	if err != nil {
@@ -442,7 +933,7 @@ func LoadEntity(id int64) (EntityOrCollection, error) {
 }
 
 func LoadJob(id int64) (Job, error) {
-	rows, err := db.Query("SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode FROM jobs WHERE id = ?", id)
+	rows, err := db.Query("SELECT id, entityId, name, status, created, earliestStart, started, ended, auth, cmd, env, tag, runner, exitCode, cache, limits, message FROM jobs WHERE id = ?", id)
 	if err != nil {
 		return Job{}, err
 	}
@@ -457,8 +948,24 @@ func LoadJob(id int64) (Job, error) {
 	return Job{}, ErrNotFound
 }
 
+func LoadKey(id int64) (Key, error) {
+	rows, err := db.Query("SELECT id, kind, ownerId, auth, created, expires, revoked, lastUsed FROM keys WHERE id = ?", id)
+	if err != nil {
+		return Key{}, err
+	}
+	if rows.Next() {
+		key, err := ScanKey(rows)
+		rows.Close()
+		if err != nil {
+			return Key{}, err
+		}
+		return key, nil
+	}
+	return Key{}, ErrNotFound
+}
+
 func LoadProject(id int64) (Project, error) {
-	rows, err := db.Query("SELECT id, name, slug, auth FROM projects WHERE id = ?", id)
+	rows, err := db.Query("SELECT id, name, slug FROM projects WHERE id = ?", id)
 	if err != nil {
 		return Project{}, err
 	}
@@ -474,7 +981,7 @@ func LoadProject(id int64) (Project, error) {
 }
 
 func LoadProjects() ([]Project, error) {
-	rows, err := db.Query("SELECT id, name, slug, auth FROM projects ORDER BY name ASC")
+	rows, err := db.Query("SELECT id, name, slug FROM projects ORDER BY name ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -489,8 +996,24 @@ func LoadProjects() ([]Project, error) {
 	return results, nil
 }
 
+func LoadRegistrationTokens() ([]RegistrationToken, error) {
+	rows, err := db.Query("SELECT id, name, auth, projectId, singleUse, expires, uses, created FROM registrationTokens ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	results := []RegistrationToken{}
+	for rows.Next() {
+		token, err := ScanRegistrationToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, token)
+	}
+	return results, nil
+}
+
 func LoadRunner(id int64) (Runner, error) {
-	rows, err := db.Query("SELECT id, name, auth FROM runners WHERE id = ?", id)
+	rows, err := db.Query("SELECT id, name, version, os, arch, cpus, memory, freeDisk, hostname, tags, infoUpdated, projectId, registrationTokenId, protocol FROM runners WHERE id = ?", id)
 	if err != nil {
 		return Runner{}, err
 	}
@@ -505,8 +1028,16 @@ func LoadRunner(id int64) (Runner, error) {
 	return Runner{}, ErrNotFound
 }
 
+func LoadRunnerCheckins() (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT name, lastCheckin FROM runners WHERE lastCheckin IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	return scanCheckins(rows)
+}
+
 func LoadRunners() ([]Runner, error) {
-	rows, err := db.Query("SELECT id, name, auth FROM runners ORDER BY name ASC")
+	rows, err := db.Query("SELECT id, name, version, os, arch, cpus, memory, freeDisk, hostname, tags, infoUpdated, projectId, registrationTokenId, protocol FROM runners ORDER BY name ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -521,6 +1052,53 @@ func LoadRunners() ([]Runner, error) {
 	return results, nil
 }
 
+func LoadTagCheckins() (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT tag, lastCheckin FROM tagCheckins")
+	if err != nil {
+		return nil, err
+	}
+	return scanCheckins(rows)
+}
+
+func LoadWebhook(id int64) (Webhook, error) {
+	rows, err := db.Query("SELECT id, projectId, url, secret, events, created FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if rows.Next() {
+		webhook, err := ScanWebhook(rows)
+		rows.Close()
+		if err != nil {
+			return Webhook{}, err
+		}
+		return webhook, nil
+	}
+	return Webhook{}, ErrNotFound
+}
+
+func MarkCacheEntryUsed(id int64, now time.Time) error {
+	_, err := db.Exec("UPDATE caches SET lastUsed = ? WHERE id = ?", now.Unix(), id)
+	return err
+}
+
+// MarkJobCancelled cancels a job that has not started yet.
+// Returns ErrNotFound if the job does not exist or is not queued anymore.
+func MarkJobCancelled(jobId int64, now time.Time) error {
+	res, err := db.Exec("UPDATE jobs SET status = ?, ended = ? WHERE id = ? AND (status = ? OR status = ?)", StatusCancelled, now.Unix(), jobId, StatusSubmitted, StatusCreated)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 1 {
+		return nil
+	} else {
+		return ErrNotFound
+	}
+}
+
 func MarkJobCreated(jobId int64) error {
 	res, err := db.Exec("UPDATE jobs SET status = ? WHERE id = ? AND status = ?", StatusCreated, jobId, StatusSubmitted)
 	if err != nil {
@@ -537,8 +1115,8 @@ func MarkJobCreated(jobId int64) error {
 	}
 }
 
-func MarkJobDone(jobId int64, status int, exitCode int64, now time.Time) error {
-	res, err := db.Exec("UPDATE jobs SET status = ?, ended = ?, auth = NULL, exitCode = ? WHERE id = ?", status, now.Unix(), exitCode, jobId)
+func MarkJobDone(jobId int64, status int, exitCode int64, message string, now time.Time) error {
+	res, err := db.Exec("UPDATE jobs SET status = ?, ended = ?, auth = NULL, exitCode = ?, message = ? WHERE id = ?", status, now.Unix(), exitCode, nullString(message), jobId)
 	if err != nil {
 		return err
 	}
@@ -553,6 +1131,11 @@ func MarkJobDone(jobId int64, status int, exitCode int64, now time.Time) error {
 	}
 }
 
+func MarkKeyUsed(id int64, now time.Time) error {
+	_, err := db.Exec("UPDATE keys SET lastUsed = ? WHERE id = ?", now.Unix(), id)
+	return err
+}
+
 func MarkPrecedingJobCompleted(jobId int64) error {
 	_, err := db.Exec("DELETE FROM precedingJobs WHERE olderJob = ?", jobId)
 	if err != nil {
@@ -561,6 +1144,32 @@ func MarkPrecedingJobCompleted(jobId int64) error {
 	return nil
 }
 
+// MarkStatusUpdateDue makes a failed status update due right away with a fresh set of attempts
+func MarkStatusUpdateDue(id int64, now time.Time) error {
+	res, err := db.Exec("UPDATE statusOutbox SET attempts = 0, due = ? WHERE id = ?", now.Unix(), id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkStatusUpdateFailed records a failed attempt unless the update was queued again in the meantime, a due of time.Unix(0, 0) gives it up
+func MarkStatusUpdateFailed(id int64, revision int64, attempts int64, due time.Time, errorMessage string, now time.Time) error {
+	dueTimestamp := sql.NullInt64{}
+	if due.Unix() != 0 {
+		dueTimestamp = nullInt64(due.Unix())
+	}
+	_, err := db.Exec("UPDATE statusOutbox SET attempts = ?, due = ?, lastAttempt = ?, error = ? WHERE id = ? AND revision = ?", attempts, dueTimestamp, now.Unix(), errorMessage, id, revision)
+	return err
+}
+
 func ReserveJobForRunner(jobId int64, auth []byte, runnerId int64, now time.Time) (Job, error) {
 	res, err := db.Exec("UPDATE jobs SET status = ?, started = ?, auth = ?, runner = ? WHERE id = ? AND status = ?", StatusStarted, now.Unix(), auth, runnerId, jobId, StatusCreated)
 	if err != nil {
@@ -577,14 +1186,130 @@ func ReserveJobForRunner(jobId int64, auth []byte, runnerId int64, now time.Time
 	}
 }
 
-func ScanAdmin(rows *sql.Rows) (Admin, error) {
+func RevokeKey(id int64, now time.Time) error {
+	_, err := db.Exec("UPDATE keys SET revoked = ? WHERE id = ? AND revoked IS NULL", now.Unix(), id)
+	return err
+}
+
+// RotateKey creates a new key for the owner and lets the other keys of the owner expire at retire,
+// unless they expire earlier anyway. Other keys are revoked right away if retire is not after created.
+func RotateKey(kind string, ownerId int64, auth []byte, created time.Time, expires time.Time, retire time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO keys (id, kind, ownerId, auth, created, expires, revoked, lastUsed) VALUES (NULL, ?, ?, ?, ?, ?, NULL, NULL)", kind, nullInt64(ownerId), auth, created.Unix(), nullInt64(expires.Unix()))
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if retire.After(created) {
+		_, err = tx.Exec("UPDATE keys SET expires = ? WHERE kind = ? AND IFNULL(ownerId, 0) = ? AND id != ? AND revoked IS NULL AND (expires IS NULL OR expires > ?)", retire.Unix(), kind, ownerId, id, retire.Unix())
+	} else {
+		_, err = tx.Exec("UPDATE keys SET revoked = ? WHERE kind = ? AND IFNULL(ownerId, 0) = ? AND id != ? AND revoked IS NULL", created.Unix(), kind, ownerId, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// UpdateCollectionState stores the final state of an entity of the collection unless a newer entity of the collection
+// reached its final state already, so that an older entity finishing late does not replace the state of a newer one.
+// Returns the state stored before, or an empty string if there was none, and whether the state was stored.
+func UpdateCollectionState(collectionId int64, entityId int64, state string, now time.Time) (string, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	var created int64
+	err = tx.QueryRow("SELECT created FROM entities WHERE id = ?", entityId).Scan(&created)
+	if err != nil {
+		tx.Rollback()
+		return "", false, err
+	}
+	var previous string
+	var previousEntityId, previousCreated int64
+	err = tx.QueryRow("SELECT collectionStates.state, entities.id, entities.created FROM collectionStates INNER JOIN entities ON collectionStates.entityId = entities.id WHERE collectionStates.collectionId = ?", collectionId).Scan(&previous, &previousEntityId, &previousCreated)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return "", false, err
+	}
+	if previousCreated > created || (previousCreated == created && previousEntityId > entityId) {
+		tx.Rollback()
+		return previous, false, nil
+	}
+	_, err = tx.Exec("INSERT INTO collectionStates (collectionId, entityId, state, updated) VALUES (?, ?, ?, ?) ON CONFLICT (collectionId) DO UPDATE SET entityId = excluded.entityId, state = excluded.state, updated = excluded.updated", collectionId, entityId, state, now.Unix())
+	if err != nil {
+		tx.Rollback()
+		return "", false, err
+	}
+	return previous, true, tx.Commit()
+}
+
+// UpdateEntityState stores the aggregated state of an entity and reports whether it changed
+func UpdateEntityState(entityId int64, state string, now time.Time) (bool, error) {
+	res, err := db.Exec("INSERT INTO entityStates (entityId, state, updated) VALUES (?, ?, ?) ON CONFLICT (entityId) DO UPDATE SET state = excluded.state, updated = excluded.updated WHERE state != excluded.state", entityId, state, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func UpdateKeyAuth(id int64, auth []byte) error {
+	_, err := db.Exec("UPDATE keys SET auth = ? WHERE id = ?", auth, id)
+	return err
+}
+
+func UpdateRegistrationTokenAuth(id int64, auth []byte) error {
+	_, err := db.Exec("UPDATE registrationTokens SET auth = ? WHERE id = ?", auth, id)
+	return err
+}
+
+func UpdateRunnerCheckin(name string, checkin time.Time) error {
+	_, err := db.Exec("UPDATE runners SET lastCheckin = ? WHERE name = ?", checkin.Unix(), name)
+	return err
+}
+
+func UpdateRunnerInfo(runnerId int64, info api.RunnerInfo, tags []string, now time.Time) error {
+	_, err := db.Exec("UPDATE runners SET os = ?, arch = ?, cpus = ?, memory = ?, freeDisk = ?, hostname = ?, tags = ?, infoUpdated = ? WHERE id = ?", info.Os, info.Arch, info.Cpus, info.Memory, info.FreeDisk, info.Hostname, strings.Join(tags, "\n"), now.Unix(), runnerId)
+	return err
+}
+
+func UpdateRunnerProtocol(runnerId int64, version string, protocol int) error {
+	_, err := db.Exec("UPDATE runners SET version = ?, protocol = ? WHERE id = ?", nullString(version), protocol, runnerId)
+	return err
+}
+
+func UpdateTagCheckin(tag string, checkin time.Time) error {
+	_, err := db.Exec("INSERT INTO tagCheckins (id, tag, lastCheckin) VALUES (NULL, ?, ?) ON CONFLICT (tag) DO UPDATE SET lastCheckin = excluded.lastCheckin", tag, checkin.Unix())
+	return err
+}
+
+func ScanCacheEntry(rows *sql.Rows) (CacheEntry, error) {
 	var id int64
-	var auth []byte
-	err := rows.Scan(&id, &auth)
+	var projectId int64
+	var key string
+	var size int64
+	var createdTimestamp int64
+	var lastUsedTimestamp int64
+	err := rows.Scan(&id, &projectId, &key, &size, &createdTimestamp, &lastUsedTimestamp)
 	if err != nil {
-		return Admin{}, err
+		return CacheEntry{}, err
 	}
-	return Admin{Id: id, Auth: auth}, nil
+	created := time.Unix(createdTimestamp, 0)
+	lastUsed := time.Unix(lastUsedTimestamp, 0)
+	return CacheEntry{Id: id, ProjectId: projectId, Key: key, Size: size, Created: created, LastUsed: lastUsed}, nil
 }
 
 func ScanEntityOrCollection(rows *sql.Rows) (EntityOrCollection, error) {
@@ -616,7 +1341,10 @@ func ScanJob(rows *sql.Rows) (Job, error) {
 	var tag string
 	var runnerId sql.NullInt64
 	var exitCode int64
-	err := rows.Scan(&id, &entityId, &name, &statusInt, &createdTimestamp, &earliestStartTimestamp, &startedTimestamp, &endedTimestamp, &auth, &cmd, &env, &tag, &runnerId, &exitCode)
+	var cache sql.NullString
+	var limits sql.NullString
+	var message sql.NullString
+	err := rows.Scan(&id, &entityId, &name, &statusInt, &createdTimestamp, &earliestStartTimestamp, &startedTimestamp, &endedTimestamp, &auth, &cmd, &env, &tag, &runnerId, &exitCode, &cache, &limits, &message)
 	if err != nil {
 		return Job{}, err
 	}
@@ -640,30 +1368,169 @@ func ScanJob(rows *sql.Rows) (Job, error) {
 	if runnerId.Valid {
 		runner = runnerId.Int64
 	}
-	return Job{Id: id, EntityId: entityId, Name: name, Status: status, Created: created, EarliestStart: earliestStart, Started: started, Ended: ended, Auth: auth, Cmd: cmd, Env: env, Tag: tag, Runner: runner, ExitCode: exitCode}, nil
+	return Job{Id: id, EntityId: entityId, Name: name, Status: status, Created: created, EarliestStart: earliestStart, Started: started, Ended: ended, Auth: auth, Cmd: cmd, Env: env, Tag: tag, Runner: runner, ExitCode: exitCode, Cache: cache.String, Limits: limits.String, Message: message.String}, nil
+}
+
+func ScanKey(rows *sql.Rows) (Key, error) {
+	var id int64
+	var kind string
+	var ownerId sql.NullInt64
+	var auth []byte
+	var createdTimestamp int64
+	var expiresTimestamp sql.NullInt64
+	var revokedTimestamp sql.NullInt64
+	var lastUsedTimestamp sql.NullInt64
+	err := rows.Scan(&id, &kind, &ownerId, &auth, &createdTimestamp, &expiresTimestamp, &revokedTimestamp, &lastUsedTimestamp)
+	if err != nil {
+		return Key{}, err
+	}
+	created := time.Unix(createdTimestamp, 0)
+	expires := time.Unix(expiresTimestamp.Int64, 0)
+	revoked := time.Unix(revokedTimestamp.Int64, 0)
+	lastUsed := time.Unix(lastUsedTimestamp.Int64, 0)
+	return Key{Id: id, Kind: kind, OwnerId: ownerId.Int64, Auth: auth, Created: created, Expires: expires, Revoked: revoked, LastUsed: lastUsed}, nil
 }
 
 func ScanProject(rows *sql.Rows) (Project, error) {
 	var id int64
 	var name string
 	var slug string
-	var auth []byte
-	err := rows.Scan(&id, &name, &slug, &auth)
+	err := rows.Scan(&id, &name, &slug)
 	if err != nil {
 		return Project{}, err
 	}
-	return Project{Id: id, Name: name, Slug: slug, Auth: auth}, nil
+	return Project{Id: id, Name: name, Slug: slug}, nil
 }
 
-func ScanRunner(rows *sql.Rows) (Runner, error) {
+func ScanRegistrationToken(rows *sql.Rows) (RegistrationToken, error) {
 	var id int64
 	var name string
 	var auth []byte
-	err := rows.Scan(&id, &name, &auth)
+	var projectId sql.NullInt64
+	var singleUse bool
+	var expiresTimestamp sql.NullInt64
+	var uses int64
+	var createdTimestamp int64
+	err := rows.Scan(&id, &name, &auth, &projectId, &singleUse, &expiresTimestamp, &uses, &createdTimestamp)
+	if err != nil {
+		return RegistrationToken{}, err
+	}
+	expires := time.Unix(0, 0)
+	if expiresTimestamp.Valid {
+		expires = time.Unix(expiresTimestamp.Int64, 0)
+	}
+	created := time.Unix(createdTimestamp, 0)
+	return RegistrationToken{Id: id, Name: name, Auth: auth, ProjectId: projectId.Int64, SingleUse: singleUse, Expires: expires, Uses: uses, Created: created}, nil
+}
+
+func ScanRunner(rows *sql.Rows) (Runner, error) {
+	var id int64
+	var name string
+	var version sql.NullString
+	var os sql.NullString
+	var arch sql.NullString
+	var cpus sql.NullInt64
+	var memory sql.NullInt64
+	var freeDisk sql.NullInt64
+	var hostname sql.NullString
+	var tags sql.NullString
+	var infoUpdatedTimestamp sql.NullInt64
+	var projectId sql.NullInt64
+	var registrationTokenId sql.NullInt64
+	var protocol sql.NullInt64
+	err := rows.Scan(&id, &name, &version, &os, &arch, &cpus, &memory, &freeDisk, &hostname, &tags, &infoUpdatedTimestamp, &projectId, &registrationTokenId, &protocol)
 	if err != nil {
 		return Runner{}, err
 	}
-	return Runner{Id: id, Name: name, Auth: auth}, nil
+	tagList := []string{}
+	if tags.String != "" {
+		tagList = strings.Split(tags.String, "\n")
+	}
+	infoUpdated := time.Unix(0, 0)
+	if infoUpdatedTimestamp.Valid {
+		infoUpdated = time.Unix(infoUpdatedTimestamp.Int64, 0)
+	}
+	return Runner{Id: id, Name: name, Version: version.String, Os: os.String, Arch: arch.String, Cpus: cpus.Int64, Memory: memory.Int64, FreeDisk: freeDisk.Int64, Hostname: hostname.String, Tags: tagList, InfoUpdated: infoUpdated, ProjectId: projectId.Int64, RegistrationTokenId: registrationTokenId.Int64, Protocol: protocol.Int64}, nil
+}
+
+func ScanStatusUpdate(rows *sql.Rows) (StatusUpdate, error) {
+	var id int64
+	var integration string
+	var entityId int64
+	var revision int64
+	var attempts int64
+	var dueTimestamp sql.NullInt64
+	var updatedTimestamp int64
+	var lastAttemptTimestamp sql.NullInt64
+	var errorMessage sql.NullString
+	err := rows.Scan(&id, &integration, &entityId, &revision, &attempts, &dueTimestamp, &updatedTimestamp, &lastAttemptTimestamp, &errorMessage)
+	if err != nil {
+		return StatusUpdate{}, err
+	}
+	due := time.Unix(0, 0)
+	if dueTimestamp.Valid {
+		due = time.Unix(dueTimestamp.Int64, 0)
+	}
+	updated := time.Unix(updatedTimestamp, 0)
+	lastAttempt := time.Unix(0, 0)
+	if lastAttemptTimestamp.Valid {
+		lastAttempt = time.Unix(lastAttemptTimestamp.Int64, 0)
+	}
+	return StatusUpdate{Id: id, Integration: integration, EntityId: entityId, Revision: revision, Attempts: attempts, Due: due, Updated: updated, LastAttempt: lastAttempt, Error: errorMessage.String}, nil
+}
+
+func ScanWebhook(rows *sql.Rows) (Webhook, error) {
+	var id int64
+	var projectId int64
+	var url string
+	var secret string
+	var events string
+	var createdTimestamp int64
+	err := rows.Scan(&id, &projectId, &url, &secret, &events, &createdTimestamp)
+	if err != nil {
+		return Webhook{}, err
+	}
+	created := time.Unix(createdTimestamp, 0)
+	return Webhook{Id: id, ProjectId: projectId, Url: url, Secret: secret, Events: strings.Split(events, ","), Created: created}, nil
+}
+
+func ScanWebhookDelivery(rows *sql.Rows) (WebhookDelivery, error) {
+	var id int64
+	var webhookId int64
+	var url string
+	var event string
+	var createdTimestamp int64
+	var statusCode sql.NullInt64
+	var errorMessage sql.NullString
+	var duration int64
+	err := rows.Scan(&id, &webhookId, &url, &event, &createdTimestamp, &statusCode, &errorMessage, &duration)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	created := time.Unix(createdTimestamp, 0)
+	return WebhookDelivery{Id: id, WebhookId: webhookId, Url: url, Event: event, Created: created, StatusCode: statusCode.Int64, Error: errorMessage.String, Duration: time.Duration(duration) * time.Millisecond}, nil
+}
+
+func scanCheckins(rows *sql.Rows) (map[string]time.Time, error) {
+	results := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		var checkinTimestamp int64
+		err := rows.Scan(&name, &checkinTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		results[name] = time.Unix(checkinTimestamp, 0)
+	}
+	return results, nil
+}
+
+func nullInt64(i int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: i, Valid: i != 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 func tryExec(tx *sql.Tx, query string, args ...any) {
@@ -697,6 +1564,92 @@ func InitializeDatabase() error {
 	return tx.Commit()
 }
 
+// migrations contains the schema changes made after the initial schema in InitializeDatabase.
+// The index of the last applied migration plus one is stored as the user_version of the database.
+// Only ever append to this list.
+var migrations = [][]string{
+	{
+		"ALTER TABLE jobs ADD COLUMN cache TEXT",
+		"CREATE TABLE caches (id INTEGER PRIMARY KEY, projectId INTEGER NOT NULL, key TEXT NOT NULL, size INTEGER NOT NULL, created INTEGER NOT NULL, lastUsed INTEGER NOT NULL, FOREIGN KEY (projectId) REFERENCES projects(id))",
+	},
+	{
+		"ALTER TABLE jobs ADD COLUMN limits TEXT",
+		"ALTER TABLE jobs ADD COLUMN message TEXT",
+	},
+	{
+		"ALTER TABLE runners ADD COLUMN version TEXT",
+		"ALTER TABLE runners ADD COLUMN os TEXT",
+		"ALTER TABLE runners ADD COLUMN arch TEXT",
+		"ALTER TABLE runners ADD COLUMN cpus INTEGER",
+		"ALTER TABLE runners ADD COLUMN memory INTEGER",
+		"ALTER TABLE runners ADD COLUMN freeDisk INTEGER",
+		"ALTER TABLE runners ADD COLUMN hostname TEXT",
+		"ALTER TABLE runners ADD COLUMN tags TEXT",
+		"ALTER TABLE runners ADD COLUMN infoUpdated INTEGER",
+	},
+	{
+		"CREATE TABLE registrationTokens (id INTEGER PRIMARY KEY, name TEXT NOT NULL, auth BLOB NOT NULL, projectId INTEGER, singleUse INTEGER NOT NULL, expires INTEGER, uses INTEGER NOT NULL, created INTEGER NOT NULL, FOREIGN KEY (projectId) REFERENCES projects(id))",
+		"ALTER TABLE runners ADD COLUMN projectId INTEGER REFERENCES projects(id)",
+		"ALTER TABLE runners ADD COLUMN registrationTokenId INTEGER REFERENCES registrationTokens(id)",
+	},
+	{
+		"ALTER TABLE runners ADD COLUMN lastCheckin INTEGER",
+		"CREATE TABLE tagCheckins (id INTEGER PRIMARY KEY, tag TEXT NOT NULL UNIQUE, lastCheckin INTEGER NOT NULL)",
+	},
+	{
+		"ALTER TABLE runners ADD COLUMN protocol INTEGER",
+	},
+	{
+		"CREATE TABLE webhooks (id INTEGER PRIMARY KEY, projectId INTEGER NOT NULL, url TEXT NOT NULL, secret TEXT NOT NULL, events TEXT NOT NULL, created INTEGER NOT NULL, FOREIGN KEY (projectId) REFERENCES projects(id))",
+		"CREATE TABLE webhookDeliveries (id INTEGER PRIMARY KEY, webhookId INTEGER NOT NULL, event TEXT NOT NULL, created INTEGER NOT NULL, statusCode INTEGER, error TEXT, duration INTEGER NOT NULL, FOREIGN KEY (webhookId) REFERENCES webhooks(id))",
+		"CREATE TABLE entityStates (entityId INTEGER PRIMARY KEY, state TEXT NOT NULL, updated INTEGER NOT NULL, FOREIGN KEY (entityId) REFERENCES entities(id))",
+	},
+	{
+		"CREATE TABLE collectionStates (collectionId INTEGER PRIMARY KEY, entityId INTEGER NOT NULL, state TEXT NOT NULL, updated INTEGER NOT NULL, FOREIGN KEY (collectionId) REFERENCES collections(id), FOREIGN KEY (entityId) REFERENCES entities(id))",
+	},
+	{
+		"CREATE TABLE statusOutbox (id INTEGER PRIMARY KEY, integration TEXT NOT NULL, entityId INTEGER NOT NULL, revision INTEGER NOT NULL, attempts INTEGER NOT NULL, due INTEGER, updated INTEGER NOT NULL, lastAttempt INTEGER, error TEXT, UNIQUE (integration, entityId), FOREIGN KEY (entityId) REFERENCES entities(id))",
+	},
+	{
+		"CREATE TABLE keys (id INTEGER PRIMARY KEY, kind TEXT NOT NULL, ownerId INTEGER, auth BLOB NOT NULL, created INTEGER NOT NULL, expires INTEGER, revoked INTEGER, lastUsed INTEGER)",
+		"CREATE INDEX keysOwner ON keys (kind, ownerId)",
+		"INSERT INTO keys (id, kind, ownerId, auth, created, expires, revoked, lastUsed) SELECT NULL, 'admin', NULL, auth, CAST(strftime('%s', 'now') AS INTEGER), NULL, NULL, NULL FROM admins",
+		"INSERT INTO keys (id, kind, ownerId, auth, created, expires, revoked, lastUsed) SELECT NULL, 'project', id, auth, CAST(strftime('%s', 'now') AS INTEGER), NULL, NULL, NULL FROM projects",
+		"INSERT INTO keys (id, kind, ownerId, auth, created, expires, revoked, lastUsed) SELECT NULL, 'runner', id, auth, CAST(strftime('%s', 'now') AS INTEGER), NULL, NULL, NULL FROM runners",
+		"DROP TABLE admins",
+		"ALTER TABLE projects DROP COLUMN auth",
+		"ALTER TABLE runners DROP COLUMN auth",
+	},
+	{
+		"UPDATE runners SET name = name || '-' || id WHERE id NOT IN (SELECT MIN(id) FROM runners GROUP BY name)",
+		"CREATE UNIQUE INDEX runnersName ON runners (name)",
+	},
+}
+
+func MigrateDatabase() error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		log.Printf("Migrating database to version %d...", version+1)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, query := range migrations[version] {
+			tryExec(tx, query)
+		}
+		tryExec(tx, fmt.Sprintf("PRAGMA user_version = %d", version+1))
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func FillDatabaseWithDemoData() error {
 	t := time.Now()
 	tx, err := db.Begin()
@@ -716,9 +1669,12 @@ func FillDatabaseWithDemoData() error {
 	if err != nil {
 		return err
 	}
-	tryExec(tx, "INSERT INTO runners (id, name, auth) VALUES (NULL, ?, ?)", "buildbox-windows", authWindows)
-	tryExec(tx, "INSERT INTO runners (id, name, auth) VALUES (NULL, ?, ?)", "buildbox-linux", authLinux)
-	tryExec(tx, "INSERT INTO runners (id, name, auth) VALUES (NULL, ?, ?)", "buildbox-macos", authMacos)
+	tryExec(tx, "INSERT INTO runners (id, name) VALUES (NULL, ?)", "buildbox-windows")
+	tryExec(tx, "INSERT INTO runners (id, name) VALUES (NULL, ?)", "buildbox-linux")
+	tryExec(tx, "INSERT INTO runners (id, name) VALUES (NULL, ?)", "buildbox-macos")
+	tryExec(tx, "INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, 'runner', 1, ?, ?)", authWindows, t.Unix())
+	tryExec(tx, "INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, 'runner', 2, ?, ?)", authLinux, t.Unix())
+	tryExec(tx, "INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, 'runner', 3, ?, ?)", authMacos, t.Unix())
 
 	authColors, err := GenerateFromPassword(PrefixProject + "colors-00000000000000000000000000000000000")
 	if err != nil {
@@ -732,9 +1688,18 @@ func FillDatabaseWithDemoData() error {
 	if err != nil {
 		return err
 	}
-	tryExec(tx, "INSERT INTO projects (id, name, slug, auth) VALUES (NULL, ?, ?, ?)", "Colors", "colors", authColors)
-	tryExec(tx, "INSERT INTO projects (id, name, slug, auth) VALUES (NULL, ?, ?, ?)", "Darke", "darke", authDarke)
-	tryExec(tx, "INSERT INTO projects (id, name, slug, auth) VALUES (NULL, ?, ?, ?)", "Aura", "aura", authAura)
+	tryExec(tx, "INSERT INTO projects (id, name, slug) VALUES (NULL, ?, ?)", "Colors", "colors")
+	tryExec(tx, "INSERT INTO projects (id, name, slug) VALUES (NULL, ?, ?)", "Darke", "darke")
+	tryExec(tx, "INSERT INTO projects (id, name, slug) VALUES (NULL, ?, ?)", "Aura", "aura")
+	tryExec(tx, "INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, 'project', 1, ?, ?)", authColors, t.Unix())
+	tryExec(tx, "INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, 'project', 2, ?, ?)", authDarke, t.Unix())
+	tryExec(tx, "INSERT INTO keys (id, kind, ownerId, auth, created) VALUES (NULL, 'project', 3, ?, ?)", authAura, t.Unix())
+
+	authRegistration, err := GenerateFromPassword(PrefixRegistration + "colors-00000000000000000000000000000000000")
+	if err != nil {
+		return err
+	}
+	tryExec(tx, "INSERT INTO registrationTokens (id, name, auth, projectId, singleUse, expires, uses, created) VALUES (NULL, ?, ?, 1, 0, NULL, 0, ?)", "colors-runners", authRegistration, t.Unix())
 
 	for i := 1; i <= 135; i++ {
 		dt := t.Add(time.Duration(-(730-i)*24) * time.Hour)
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// diagnoseJob explains everything that currently prevents a queued job from starting
+func diagnoseJob(job Job, now time.Time) (api.JobDiagnosticsResponse, error) {
+	diagnostics := api.JobDiagnosticsResponse{Id: job.Id, Status: jobStatus(job.Status), Blockers: []api.JobBlocker{}}
+	if job.Status != StatusSubmitted && job.Status != StatusCreated {
+		return diagnostics, nil
+	}
+
+	if job.Status == StatusSubmitted {
+		diagnostics.Blockers = append(diagnostics.Blockers, api.JobBlocker{
+			Kind:    api.BlockerSubmitted,
+			Message: "the controller has not finished processing the submission of this job",
+		})
+	}
+
+	precedingJobs, err := FindPrecedingJobs(job.Id)
+	if err != nil {
+		return api.JobDiagnosticsResponse{}, err
+	}
+	if len(precedingJobs) > 0 {
+		ids := []int64{}
+		names := []string{}
+		for _, precedingJob := range precedingJobs {
+			ids = append(ids, precedingJob.Id)
+			names = append(names, fmt.Sprintf("%s (#%d, %s)", precedingJob.Name, precedingJob.Id, jobStatus(precedingJob.Status)))
+		}
+		diagnostics.Blockers = append(diagnostics.Blockers, api.JobBlocker{
+			Kind:    api.BlockerPrecedingJobs,
+			Message: fmt.Sprintf("waiting for preceding jobs to complete: %s", strings.Join(names, ", ")),
+			JobIds:  ids,
+		})
+	}
+
+	if job.EarliestStart.After(now) {
+		diagnostics.Blockers = append(diagnostics.Blockers, api.JobBlocker{
+			Kind:    api.BlockerEarliestStart,
+			Message: fmt.Sprintf("the job may not start before %s", job.EarliestStart.Format("2006-01-02 15:04:05")),
+			Until:   job.EarliestStart.Unix(),
+		})
+	}
+
+	blocker, err := diagnoseRunners(job, now)
+	if err != nil {
+		return api.JobDiagnosticsResponse{}, err
+	}
+	if blocker != nil {
+		diagnostics.Blockers = append(diagnostics.Blockers, *blocker)
+	}
+
+	diagnostics.QueuePosition, diagnostics.QueueLength, err = FindQueuePosition(job)
+	if err != nil {
+		return api.JobDiagnosticsResponse{}, err
+	}
+	diagnostics.Ready = job.Status == StatusCreated && len(diagnostics.Blockers) == 0
+	return diagnostics, nil
+}
+
+// diagnoseRunners checks whether any runner that may run the job is around and idle
+func diagnoseRunners(job Job, now time.Time) (*api.JobBlocker, error) {
+	entity, err := LoadEntity(job.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	runners, err := LoadRunners()
+	if err != nil {
+		return nil, err
+	}
+	startedJobs, err := CountStartedJobsPerRunner()
+	if err != nil {
+		return nil, err
+	}
+	runnerCheckins := presence.Runners()
+
+	// runners busy with a long job do not check in, so stale runners are counted as well
+	busy := []string{}
+	outdated := []string{}
+	for _, runner := range runners {
+		if runner.ProjectId > 0 && runner.ProjectId != entity.ProjectId {
+			continue
+		}
+		if !hasTag(runner.Tags, job.Tag) {
+			continue
+		}
+		checkin, found := runnerCheckins[runner.Name]
+		state := presenceState(checkin, found, now)
+		if compatibilityMode(runner.Protocol) && (job.Cache != "" || job.Limits != "") {
+			if state == PresenceOnline {
+				outdated = append(outdated, runner.Name)
+			}
+			continue
+		}
+		if startedJobs[runner.Id] > 0 && (state == PresenceOnline || state == PresenceStale) {
+			busy = append(busy, runner.Name)
+		} else if state == PresenceOnline {
+			return nil, nil
+		}
+	}
+	if len(busy) > 0 {
+		return &api.JobBlocker{
+			Kind:    api.BlockerRunnersBusy,
+			Message: fmt.Sprintf("all runners that may run this job are busy: %s", strings.Join(busy, ", ")),
+			Runners: busy,
+		}, nil
+	}
+
+	if len(outdated) > 0 {
+		return &api.JobBlocker{
+			Kind:    api.BlockerRunnersOutdated,
+			Message: fmt.Sprintf("the job uses cache or limits which these runners do not support, update them: %s", strings.Join(outdated, ", ")),
+			Runners: outdated,
+		}, nil
+	}
+
+	// runners that do not report their tags are only known through the tag check-ins
+	tagCheckin, found := presence.Tags()[job.Tag]
+	if presenceState(tagCheckin, found, now) == PresenceOnline {
+		return nil, nil
+	}
+	return &api.JobBlocker{
+		Kind:    api.BlockerNoRunner,
+		Message: fmt.Sprintf("no runner that may run this job has checked in with the tag %s recently", job.Tag),
+	}, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
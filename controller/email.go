@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logTailLines is the number of lines of the log included for every failed job
+const logTailLines = 20
+
+// logWaitTimeout is how long to wait for the log of a failed job, runners upload it after reporting the result
+const logWaitTimeout = 30 * time.Second
+
+// emailNotification describes a watched collection whose state changed with an entity
+type emailNotification struct {
+	Project    Project
+	Collection EntityOrCollection
+	Entity     EntityOrCollection
+	State      string
+	FailedJobs []emailJob
+}
+
+type emailJob struct {
+	Id       int64
+	Name     string
+	ExitCode int64
+	Message  string
+	LogTail  string
+}
+
+// emailMutex serializes updating the collection states and sending the notifications,
+// so that they are sent in the order the states changed
+var emailMutex sync.Mutex
+
+// emailDigests collects the notifications for projects with a digest until they are sent.
+// The pending notifications are keyed by project slug and recipient.
+var emailDigests = struct {
+	sync.Mutex
+	pending map[[2]string][]emailNotification
+}{pending: map[[2]string][]emailNotification{}}
+
+// notifyByEmail notifies the recipients of the watched collections of the entity if their state changed.
+// Only changes between success and failure are notified, a first failure counts as a change as well.
+// Entities finishing after a newer entity of the collection do not change its state.
+func notifyByEmail(project Project, entity EntityOrCollection, state string) {
+	if config.Email.Server == "" || (state != "success" && state != "failure") {
+		return
+	}
+	projectCfg, found := config.Email.Projects[project.Slug]
+	if !found {
+		return
+	}
+	collections, err := FindCollectionsOfEntity(entity.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	watched := []EntityOrCollection{}
+	for _, collection := range collections {
+		if _, found := projectCfg.Collections[collection.Key+"/"+collection.Val]; found {
+			watched = append(watched, collection)
+		}
+	}
+	if len(watched) == 0 {
+		return
+	}
+	// the logs are waited for before taking the mutex, so that a job without a log does not hold up other notifications
+	var failedJobs []emailJob
+	if state == "failure" {
+		failedJobs, err = loadFailedJobs(entity.Id)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	emailMutex.Lock()
+	defer emailMutex.Unlock()
+	t := time.Now()
+	for _, collection := range watched {
+		collectionRecipients := projectCfg.Collections[collection.Key+"/"+collection.Val]
+		previous, updated, err := UpdateCollectionState(collection.Id, entity.Id, state, t)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if !updated || previous == state || (previous == "" && state == "success") {
+			continue
+		}
+		n := emailNotification{Project: project, Collection: collection, Entity: entity, State: state, FailedJobs: failedJobs}
+		recipients := uniqueRecipients(projectCfg.Recipients, collectionRecipients)
+		if projectCfg.Digest > 0 {
+			for _, recipient := range recipients {
+				queueEmailDigest(project.Slug, recipient, n, time.Duration(projectCfg.Digest)*time.Second)
+			}
+			continue
+		}
+		subject, body := formatEmailNotification(n)
+		err = sendEmail(recipients, subject, body)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// loadFailedJobs returns the failed jobs among the most recent jobs with each name of the entity.
+// Logs that have not been uploaded yet are waited for until logWaitTimeout has passed for all of them together.
+func loadFailedJobs(entityId int64) ([]emailJob, error) {
+	jobs, err := FindJobs(entityId)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]Job{}
+	for _, job := range jobs {
+		if previous, found := latest[job.Name]; !found || job.Id > previous.Id {
+			latest[job.Name] = job
+		}
+	}
+	results := []emailJob{}
+	deadline := time.Now().Add(logWaitTimeout)
+	for _, job := range latest {
+		if job.Status != StatusFailed {
+			continue
+		}
+		logTail, found, err := readLogTail(job.Id, logTailLines)
+		for err == nil && !found && time.Now().Before(deadline) {
+			time.Sleep(500 * time.Millisecond)
+			logTail, found, err = readLogTail(job.Id, logTailLines)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, emailJob{Id: job.Id, Name: job.Name, ExitCode: job.ExitCode, Message: job.Message, LogTail: logTail})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, nil
+}
+
+// readLogTail returns the last lines of the log of a job and whether the job has a log
+func readLogTail(jobId int64, lines int) (string, bool, error) {
+	file, err := os.Open(filepath.Join("artifacts", fmt.Sprintf("%d", jobId), "log"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	defer file.Close()
+	// the tail is taken from the end of the file only so that huge logs are not read completely
+	info, err := file.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	offset := info.Size() - 64*1024
+	if offset > 0 {
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", false, err
+	}
+	logLines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(logLines) > lines {
+		logLines = logLines[len(logLines)-lines:]
+	}
+	return strings.Join(logLines, "\n"), true, nil
+}
+
+func uniqueRecipients(lists ...[]string) []string {
+	seen := map[string]bool{}
+	results := []string{}
+	for _, list := range lists {
+		for _, recipient := range list {
+			if !seen[recipient] {
+				seen[recipient] = true
+				results = append(results, recipient)
+			}
+		}
+	}
+	return results
+}
+
+func queueEmailDigest(projectSlug string, recipient string, n emailNotification, digest time.Duration) {
+	key := [2]string{projectSlug, recipient}
+	emailDigests.Lock()
+	defer emailDigests.Unlock()
+	if len(emailDigests.pending[key]) == 0 {
+		time.AfterFunc(digest, func() { sendEmailDigest(key) })
+	}
+	emailDigests.pending[key] = append(emailDigests.pending[key], n)
+}
+
+func sendEmailDigest(key [2]string) {
+	emailDigests.Lock()
+	notifications := emailDigests.pending[key]
+	delete(emailDigests.pending, key)
+	emailDigests.Unlock()
+	if len(notifications) == 0 {
+		return
+	}
+
+	subject, body := formatEmailNotification(notifications[0])
+	if len(notifications) > 1 {
+		subject = fmt.Sprintf("[Aura] %s: %d changes", notifications[0].Project.Name, len(notifications))
+		bodies := []string{}
+		for _, n := range notifications {
+			_, b := formatEmailNotification(n)
+			bodies = append(bodies, b)
+		}
+		body = strings.Join(bodies, "\n----------------------------------------\n\n")
+	}
+	err := sendEmail([]string{key[1]}, subject, body)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func formatEmailNotification(n emailNotification) (string, string) {
+	collection := n.Collection.Key + "/" + n.Collection.Val
+	entity := n.Entity.Key + "/" + n.Entity.Val
+	entityUrl := fmt.Sprintf("%s/p/%s/%s/%s", config.BaseUrl, n.Project.Slug, n.Entity.Key, n.Entity.Val)
+
+	body := &strings.Builder{}
+	if n.State == "failure" {
+		subject := fmt.Sprintf("[Aura] %s %s failed at %s", n.Project.Name, collection, entity)
+		fmt.Fprintf(body, "%s of %s is failing since %s.\n%s\n", collection, n.Project.Name, entity, entityUrl)
+		for _, job := range n.FailedJobs {
+			fmt.Fprintf(body, "\n%s (#%d) failed with exit code %d", job.Name, job.Id, job.ExitCode)
+			if job.Message != "" {
+				fmt.Fprintf(body, ": %s", job.Message)
+			}
+			fmt.Fprintf(body, "\n%s/j/%d\n", config.BaseUrl, job.Id)
+			if job.LogTail != "" {
+				fmt.Fprintf(body, "\n    %s\n", strings.ReplaceAll(job.LogTail, "\n", "\n    "))
+			}
+		}
+		return subject, body.String()
+	}
+	subject := fmt.Sprintf("[Aura] %s %s recovered at %s", n.Project.Name, collection, entity)
+	fmt.Fprintf(body, "%s of %s is succeeding again since %s.\n%s\n", collection, n.Project.Name, entity, entityUrl)
+	return subject, body.String()
+}
+
+// sendEmail sends a plain text email through the configured SMTP server
+func sendEmail(to []string, subject string, body string) error {
+	if len(to) == 0 {
+		return nil
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", config.Email.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(msg, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(msg, "\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if config.Email.Username != "" {
+		host, _, err := net.SplitHostPort(config.Email.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", config.Email.Username, config.Email.Password, host)
+	}
+	return smtp.SendMail(config.Email.Server, auth, config.Email.From, to, msg.Bytes())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer accepts a single email and sends its data to the returned channel
+func fakeSmtpServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 fake\r\n"))
+		data := &strings.Builder{}
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					conn.Write([]byte("250 ok\r\n"))
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch strings.ToUpper(strings.Fields(line)[0]) {
+			case "DATA":
+				inData = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSendEmailNotification(t *testing.T) {
+	server, received := fakeSmtpServer(t)
+	config.BaseUrl = "http://aura.example"
+	config.Email = EmailConfig{Server: server, From: "aura@example.com"}
+
+	n := emailNotification{
+		Project:    Project{Name: "Colors", Slug: "colors"},
+		Collection: EntityOrCollection{Key: "ref", Val: "main"},
+		Entity:     EntityOrCollection{Key: "rev", Val: "abc"},
+		State:      "failure",
+		FailedJobs: []emailJob{{Id: 42, Name: "test", ExitCode: 2, LogTail: "FAIL: TestColors"}},
+	}
+	subject, body := formatEmailNotification(n)
+	err := sendEmail([]string{"team@example.com"}, subject, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := <-received
+	for _, expected := range []string{
+		"Subject: [Aura] Colors ref/main failed at rev/abc",
+		"To: team@example.com",
+		"test (#42) failed with exit code 2",
+		"http://aura.example/j/42",
+		"http://aura.example/p/colors/rev/abc",
+		"    FAIL: TestColors",
+	} {
+		if !strings.Contains(data, expected) {
+			t.Errorf("expected %q in email:\n%s", expected, data)
+		}
+	}
+}
+
+func TestNotifyByEmailDigest(t *testing.T) {
+	project := setupTestDatabase(t)
+	config.BaseUrl = "http://aura.example"
+	config.Email = EmailConfig{
+		Server: "127.0.0.1:0",
+		From:   "aura@example.com",
+		Projects: map[string]EmailProjectConfig{
+			"colors": {Recipients: []string{"team@example.com"}, Collections: map[string][]string{"ref/main": nil}, Digest: 3600},
+		},
+	}
+	t.Cleanup(func() {
+		config.Email = EmailConfig{}
+		emailDigests.pending = map[[2]string][]emailNotification{}
+	})
+
+	now := time.Now()
+	err := CreateCollection(project.Id, "ref", "main", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := FindCollection(project.Id, "ref", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities := map[string]EntityOrCollection{}
+	for i, val := range []string{"a", "b", "c"} {
+		err = CreateEntity(project.Id, "rev", val, now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entities[val], err = FindEntity(project.Id, "rev", val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = InsertEntityIntoCollection(collection.Id, entities[val].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := [2]string{"colors", "team@example.com"}
+	tests := []struct {
+		entity   string
+		state    string
+		notified bool
+	}{
+		{"a", "success", false}, // the first success is not notified
+		{"b", "failure", true},
+		{"a", "success", false}, // older than the entity that set the state
+		{"b", "failure", false}, // unchanged
+		{"c", "success", true},
+	}
+	for _, test := range tests {
+		before := len(emailDigests.pending[key])
+		notifyByEmail(project, entities[test.entity], test.state)
+		notified := len(emailDigests.pending[key]) > before
+		if notified != test.notified {
+			t.Errorf("rev/%s %s notified = %v, want %v", test.entity, test.state, notified, test.notified)
+		}
+	}
+
+	server, received := fakeSmtpServer(t)
+	config.Email.Server = server
+	sendEmailDigest(key)
+	data := <-received
+	for _, expected := range []string{
+		"Subject: [Aura] Colors: 2 changes",
+		"To: team@example.com",
+		"ref/main of Colors is failing since rev/b.",
+		"ref/main of Colors is succeeding again since rev/c.",
+	} {
+		if !strings.Contains(data, expected) {
+			t.Errorf("expected %q in email:\n%s", expected, data)
+		}
+	}
+	if len(emailDigests.pending[key]) != 0 {
+		t.Error("digest was not cleared after sending")
+	}
+}
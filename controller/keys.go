@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// errLastAdminKey is returned when revoking the only usable admin key, which would lock everyone out
+var errLastAdminKey = errors.New("the last usable admin key can not be revoked")
+
+// keyOwner is the admin, project or runner that keys belong to
+type keyOwner struct {
+	Kind   string
+	Id     int64  // 0 for admin keys
+	Name   string // project slug or runner name, empty for admin keys
+	Title  string // shown in the UI, e.g. "Project Colors"
+	Url    string // page of the owner
+	Label  string // keys accepted to manage the keys of the owner
+	Prefix string
+}
+
+// findKeyOwner returns the project with the slug, or the runner with the name, or the admin if both are empty
+func findKeyOwner(project string, runner string) (keyOwner, error) {
+	if project != "" {
+		p, err := FindProjectBySlug(project)
+		if err != nil {
+			return keyOwner{}, err
+		}
+		return projectKeyOwner(p), nil
+	}
+	if runner != "" {
+		rn, err := FindRunnerByName(runner)
+		if err != nil {
+			return keyOwner{}, err
+		}
+		return runnerKeyOwner(rn), nil
+	}
+	return adminKeyOwner(), nil
+}
+
+// loadKeyOwner returns the owner of the key
+func loadKeyOwner(key Key) (keyOwner, error) {
+	switch key.Kind {
+	case KeyKindProject:
+		p, err := LoadProject(key.OwnerId)
+		if err != nil {
+			return keyOwner{}, err
+		}
+		return projectKeyOwner(p), nil
+	case KeyKindRunner:
+		rn, err := LoadRunner(key.OwnerId)
+		if err != nil {
+			return keyOwner{}, err
+		}
+		return runnerKeyOwner(rn), nil
+	default:
+		return adminKeyOwner(), nil
+	}
+}
+
+func adminKeyOwner() keyOwner {
+	return keyOwner{Kind: KeyKindAdmin, Title: "Admin", Url: "/", Label: "Admin Key", Prefix: PrefixAdmin}
+}
+
+func projectKeyOwner(p Project) keyOwner {
+	return keyOwner{Kind: KeyKindProject, Id: p.Id, Name: p.Slug, Title: "Project " + p.Name, Url: "/p/" + p.Slug, Label: "Project Key or Admin Key", Prefix: PrefixProject}
+}
+
+func runnerKeyOwner(rn Runner) keyOwner {
+	return keyOwner{Kind: KeyKindRunner, Id: rn.Id, Name: rn.Name, Title: "Runner " + rn.Name, Url: "/r/" + rn.Name, Label: "Runner Key or Admin Key", Prefix: PrefixRunner}
+}
+
+// checkAuth checks auth against the keys of the owner and the admin keys
+func (o keyOwner) checkAuth(auth string) (bool, error) {
+	switch o.Kind {
+	case KeyKindProject:
+		return checkProjectAuth(o.Id, auth)
+	case KeyKindRunner:
+		return checkRunnerAuth(o.Id, auth)
+	default:
+		return checkAdminAuth(auth)
+	}
+}
+
+// rotateKey creates a new key for the owner and returns it, it is the only time the key is available.
+// The previous keys keep working for overlap, they are revoked right away if overlap is 0.
+// The new key never expires if expiresAfter is 0.
+func rotateKey(owner keyOwner, overlap time.Duration, expiresAfter time.Duration, now time.Time) (string, Key, error) {
+	pass, hash, err := GenerateRandom(owner.Prefix)
+	if err != nil {
+		return "", Key{}, err
+	}
+	expires := time.Unix(0, 0)
+	if expiresAfter > 0 {
+		expires = now.Add(expiresAfter)
+	}
+	id, err := RotateKey(owner.Kind, owner.Id, hash, now, expires, now.Add(overlap))
+	if err != nil {
+		return "", Key{}, err
+	}
+	key, err := LoadKey(id)
+	if err != nil {
+		return "", Key{}, err
+	}
+	log.Printf("Rotated key of %s", strings.ToLower(owner.Title))
+	return pass, key, nil
+}
+
+// revokeKey revokes the key unless it is the last usable admin key
+func revokeKey(key Key, now time.Time) error {
+	if key.Kind == KeyKindAdmin && key.Usable(now) {
+		keys, err := FindKeys(KeyKindAdmin, 0)
+		if err != nil {
+			return err
+		}
+		usable := 0
+		for _, k := range keys {
+			if k.Usable(now) {
+				usable++
+			}
+		}
+		if usable <= 1 {
+			return errLastAdminKey
+		}
+	}
+	return RevokeKey(key.Id, now)
+}
+
+// keyState describes the key for the UI and the API
+func keyState(key Key, now time.Time) string {
+	if key.Revoked.Unix() != 0 {
+		return "revoked"
+	}
+	if !key.Usable(now) {
+		return "expired"
+	}
+	if key.Expires.Unix() != 0 {
+		return "expiring"
+	}
+	return "active"
+}
+
+func keyInfo(key Key, owner keyOwner, now time.Time) api.KeyInfo {
+	info := api.KeyInfo{Id: key.Id, Kind: key.Kind, Owner: owner.Name, State: keyState(key, now), Created: key.Created.Unix()}
+	if key.Expires.Unix() != 0 {
+		info.Expires = key.Expires.Unix()
+	}
+	if key.Revoked.Unix() != 0 {
+		info.Revoked = key.Revoked.Unix()
+	}
+	if key.LastUsed.Unix() != 0 {
+		info.LastUsed = key.LastUsed.Unix()
+	}
+	return info
+}
+
+// parseHours parses a number of hours from a form, empty means 0
+func parseHours(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	hours, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || hours < 0 {
+		return 0, errors.New("invalid number of hours")
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
+
+func RouteKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	owner, err := findKeyOwner(query.Get("project"), query.Get("runner"))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	type dataItem struct {
+		Key   Key
+		State string
+	}
+	type data struct {
+		Authed bool
+		Items  []dataItem
+		NewKey string
+		Owner  keyOwner
+		Title  string
+	}
+	title := "Keys of " + owner.Title
+	if owner.Kind == KeyKindAdmin {
+		title = "Admin Keys"
+	}
+	d := data{Owner: owner, Title: title}
+
+	// the key is not put into the page, revoking and rotating ask for it again
+	if r.Method == http.MethodPost {
+		auth := r.FormValue("key")
+		authOk, err := owner.checkAuth(auth)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if !authOk {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		t := time.Now()
+
+		if r.FormValue("rotate") != "" {
+			overlap, err := parseHours(r.FormValue("overlap"))
+			if err != nil {
+				http.Error(w, "invalid overlap", http.StatusBadRequest)
+				return
+			}
+			expiresAfter, err := parseHours(r.FormValue("expires"))
+			if err != nil {
+				http.Error(w, "invalid expiry", http.StatusBadRequest)
+				return
+			}
+			pass, _, err := rotateKey(owner, overlap, expiresAfter, t)
+			if err != nil {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+			d.NewKey = pass
+			d.Title = "Key rotated successfully"
+			err = templates.ExecuteTemplate(w, "keys.html", d)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+
+		revoke := r.FormValue("revoke")
+		if revoke != "" {
+			id, err := strconv.ParseInt(revoke, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			key, err := LoadKey(id)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+			if key.Kind != owner.Kind || key.OwnerId != owner.Id {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			err = revokeKey(key, t)
+			if err != nil {
+				if errors.Is(err, errLastAdminKey) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+		}
+
+		keys, err := FindKeys(owner.Kind, owner.Id)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		items := []dataItem{}
+		for _, key := range keys {
+			items = append(items, dataItem{Key: key, State: keyState(key, t)})
+		}
+		d.Authed = true
+		d.Items = items
+	}
+
+	err = templates.ExecuteTemplate(w, "keys.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func RouteApiKeys(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/api/keys")
+	p = strings.TrimPrefix(p, "/")
+	if p != "" && p != "rotate" && p != "revoke" {
+		respondError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if (p == "" && r.Method != http.MethodGet) || (p != "" && r.Method != http.MethodPost) {
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) == 0 {
+		respondError(w, http.StatusUnauthorized, "missing authorization header")
+		return
+	}
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	t := time.Now()
+
+	if p == "revoke" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		var req api.KeyRevokeRequest
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+			return
+		}
+		key, err := LoadKey(req.Id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				respondError(w, http.StatusBadRequest, "unknown key")
+				return
+			}
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		owner, err := loadKeyOwner(key)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		authOk, err := owner.checkAuth(authHeader)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !authOk {
+			respondError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		err = revokeKey(key, t)
+		if err != nil {
+			if errors.Is(err, errLastAdminKey) {
+				respondError(w, http.StatusConflict, err.Error())
+				return
+			}
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		respond(w, http.StatusOK, api.KeyRevokeResponse{})
+		return
+	}
+
+	var req api.KeyRotateRequest
+	if p == "rotate" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusBadRequest, "unable to unmarshal json object")
+			return
+		}
+		if req.Overlap < 0 || req.ExpiresAfter < 0 {
+			respondError(w, http.StatusBadRequest, "invalid overlap or expiry")
+			return
+		}
+	} else {
+		req.Project = r.URL.Query().Get("project")
+		req.Runner = r.URL.Query().Get("runner")
+	}
+	owner, err := findKeyOwner(req.Project, req.Runner)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondError(w, http.StatusBadRequest, "unknown project or runner")
+			return
+		}
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	authOk, err := owner.checkAuth(authHeader)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !authOk {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if p == "rotate" {
+		pass, key, err := rotateKey(owner, time.Duration(req.Overlap)*time.Second, time.Duration(req.ExpiresAfter)*time.Second, t)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		respond(w, http.StatusOK, api.KeyRotateResponse{Key: pass, Info: keyInfo(key, owner, t)})
+		return
+	}
+
+	keys, err := FindKeys(owner.Kind, owner.Id)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := api.KeysResponse{Keys: []api.KeyInfo{}}
+	for _, key := range keys {
+		resp.Keys = append(resp.Keys, keyInfo(key, owner, t))
+	}
+	respond(w, http.StatusOK, resp)
+}
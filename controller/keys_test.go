@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRotateKey(t *testing.T) {
+	project := setupTestDatabase(t)
+	owner := projectKeyOwner(project)
+	now := time.Now()
+	check := func(auth string, at time.Time, expected bool) {
+		t.Helper()
+		authOk, err := checkKeys(KeyKindProject, project.Id, auth, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authOk != expected {
+			t.Errorf("checkKeys(%s...) at %s = %v, want %v", auth[:20], at.Sub(now), authOk, expected)
+		}
+	}
+
+	first, _, err := rotateKey(owner, 0, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(first, now, true)
+
+	// with an overlap both keys work until the previous one expires
+	second, _, err := rotateKey(owner, time.Hour, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(first, now, true)
+	check(second, now, true)
+	check(first, now.Add(2*time.Hour), false)
+	check(second, now.Add(2*time.Hour), true)
+
+	// without an overlap the previous keys stop working right away
+	third, key, err := rotateKey(owner, 0, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(first, now, false)
+	check(second, now, false)
+	check(third, now, true)
+	check(third, now.Add(2*time.Hour), false)
+	check(third, now.Add(30*time.Second), true)
+	key, err = LoadKey(key.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.LastUsed.Unix() == 0 {
+		t.Error("last use of the key was not recorded")
+	} else if key.LastUsed.Unix() != now.Unix() {
+		t.Errorf("expected the use within a minute not to be recorded, got %s", key.LastUsed.Sub(now))
+	}
+
+	err = revokeKey(key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(third, now, false)
+}
+
+func TestRevokeLastAdminKey(t *testing.T) {
+	setupTestDatabase(t)
+	now := time.Now()
+	keys, err := FindKeys(KeyKindAdmin, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("found %d admin keys, want 1", len(keys))
+	}
+	err = revokeKey(keys[0], now)
+	if !errors.Is(err, errLastAdminKey) {
+		t.Fatalf("revoking the last admin key returned %v", err)
+	}
+
+	_, _, err = rotateKey(adminKeyOwner(), time.Hour, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = revokeKey(keys[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,7 +9,8 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	_ "modernc.org/sqlite"
 )
@@ -33,7 +34,8 @@ func main() {
 		}
 	}
 	log.Printf("Opening database %s...", dbFilename)
-	db, err = sql.Open("sqlite", dbFilename)
+	// background work like webhook deliveries writes concurrently to requests, wait for locks instead of failing
+	db, err = sql.Open("sqlite", dbFilename+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -43,6 +45,10 @@ func main() {
 		if err != nil {
 			log.Fatalln(err)
 		}
+		err = MigrateDatabase()
+		if err != nil {
+			log.Fatalln(err)
+		}
 		if dbDemo {
 			log.Printf("Filling database with demo data...")
 			err = FillDatabaseWithDemoData()
@@ -50,29 +56,80 @@ func main() {
 				log.Fatalln(err)
 			}
 		}
+	} else {
+		err = MigrateDatabase()
+		if err != nil {
+			log.Fatalln(err)
+		}
 	}
 
+	LoadConfig()
 	InitializeSubmitEndpoints()
+	StartOutbox()
+
+	err = presence.Load()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go presence.FlushPeriodically()
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		err := presence.Flush()
+		if err != nil {
+			log.Println(err)
+		}
+		os.Exit(0)
+	}()
 
-	runnerCheckins = make(map[string]time.Time)
-	tagCheckins = make(map[string]time.Time)
 	templateFuncs := template.FuncMap{
-		"buildTimer": buildTimer,
+		"buildTimer":  buildTimer,
+		"formatBytes": formatBytes,
 	}
 	templates = template.Must(template.New("pages").Funcs(templateFuncs).ParseFS(templateData, "templates/*"))
 
 	router := http.NewServeMux()
 	router.Handle("/static/", http.FileServer(http.FS(staticData)))
-	router.HandleFunc("/api/job", RouteApiJob)
-	router.HandleFunc("/api/runner", RouteApiRunner)
-	router.HandleFunc("/api/storage/", RouteApiStorage)
-	router.HandleFunc("/api/submit/", RouteApiSubmit)
-	router.HandleFunc("/api/submit", RouteApiSubmit)
+	apiRoutes := []struct {
+		path    string
+		handler http.HandlerFunc
+		legacy  bool // also served without version for runners and integrations from before 0.5.0
+	}{
+		{"/cache/", RouteApiCache, false},
+		{"/cancel", RouteApiCancel, false},
+		{"/job", RouteApiJob, true},
+		{"/jobs/", RouteApiJobs, false},
+		{"/keys/", RouteApiKeys, false},
+		{"/keys", RouteApiKeys, false},
+		{"/projects/", RouteApiProjects, false},
+		{"/projects", RouteApiProjects, false},
+		{"/queue", RouteApiQueue, false},
+		{"/register", RouteApiRegister, false},
+		{"/rerun", RouteApiRerun, false},
+		{"/runner", RouteApiRunner, true},
+		{"/storage/", RouteApiStorage, true},
+		{"/submit/", RouteApiSubmit, true},
+		{"/submit", RouteApiSubmit, true},
+	}
+	for _, route := range apiRoutes {
+		router.HandleFunc("/api/v1"+route.path, versioned(route.handler))
+		if route.legacy {
+			router.HandleFunc("/api"+route.path, route.handler)
+		}
+	}
+	router.HandleFunc("/delete-webhook", RouteDeleteWebhook)
+	router.HandleFunc("/integrations", RouteIntegrations)
 	router.HandleFunc("/j/", RouteJob)
+	router.HandleFunc("/keys", RouteKeys)
 	router.HandleFunc("/new-project", RouteNewProject)
+	router.HandleFunc("/new-registration-token", RouteNewRegistrationToken)
 	router.HandleFunc("/new-runner", RouteNewRunner)
+	router.HandleFunc("/new-webhook", RouteNewWebhook)
+	router.HandleFunc("/outbox", RouteOutbox)
 	router.HandleFunc("/p/", RouteProject)
 	router.HandleFunc("/queue", RouteQueue)
+	router.HandleFunc("/r/", RouteRunner)
 	router.HandleFunc("/runners", RouteRunners)
 	router.HandleFunc("/", RouteRoot)
 
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// /api/v1/submit/<name> for integrations of type mapped
+// =============================================================================
+
+// SubmitEndpointMapped accepts arbitrary JSON and maps it to jobs with JSONPath-style expressions.
+// Every expression starting with $ is looked up in the request, everything else is used literally.
+type SubmitEndpointMapped struct {
+	Auth SubmitEndpointMappedAuth `json:"auth"`
+
+	// Entity key and value of the jobs
+	EntityKey string `json:"entityKey"`
+	EntityVal string `json:"entityVal"`
+
+	// Collection keys and the expressions for their values, collections without a value are left out
+	Collections map[string]string `json:"collections"`
+
+	// Requests have to match every filter to submit jobs, other requests are acknowledged without jobs
+	Filters []SubmitEndpointMappedFilter `json:"filters"`
+
+	// Jobs to submit, a pipeline is a first job that submits the others as child jobs
+	Jobs []GenericJobConfig `json:"jobs"`
+}
+
+type SubmitEndpointMappedAuth struct {
+	// "hmac" for an HMAC-SHA256 signature of the body, "token" for a static token
+	Type string `json:"type"`
+
+	// Header containing the signature or token
+	Header string `json:"header"`
+
+	// Prefix of the hex encoded signature, e.g. "sha256=", only used with hmac
+	Prefix string `json:"prefix"`
+
+	// Key of the signature for hmac or the expected header value for token
+	Secret string `json:"secret"`
+}
+
+type SubmitEndpointMappedFilter struct {
+	Path string `json:"path"`
+
+	// Values of which one has to match
+	Values []string `json:"values"`
+}
+
+func NewSubmitEndpointMapped(cfgData json.RawMessage) (*SubmitEndpointMapped, error) {
+	var endpoint SubmitEndpointMapped
+	err := json.Unmarshal(cfgData, &endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Auth.Type != "hmac" && endpoint.Auth.Type != "token" {
+		return nil, errors.New("auth type has to be hmac or token")
+	}
+	if endpoint.Auth.Header == "" || endpoint.Auth.Secret == "" {
+		return nil, errors.New("auth header and secret are required")
+	}
+	if endpoint.EntityKey == "" || endpoint.EntityVal == "" {
+		return nil, errors.New("entityKey and entityVal are required")
+	}
+	if len(endpoint.Jobs) == 0 {
+		return nil, errors.New("at least one job is required")
+	}
+	expressions := []string{endpoint.EntityKey, endpoint.EntityVal}
+	for _, expression := range endpoint.Collections {
+		expressions = append(expressions, expression)
+	}
+	for _, filter := range endpoint.Filters {
+		expressions = append(expressions, filter.Path)
+	}
+	for _, expression := range expressions {
+		if strings.HasPrefix(expression, "$") {
+			_, err = parseJsonPath(expression)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", expression, err)
+			}
+		}
+	}
+	return &endpoint, nil
+}
+
+func (e *SubmitEndpointMapped) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+	}
+	header := r.Header.Get(e.Auth.Header)
+	if header == "" {
+		return nil, &SubmitError{http.StatusUnauthorized, "missing " + e.Auth.Type, nil}
+	}
+	expected := e.Auth.Secret
+	if e.Auth.Type == "hmac" {
+		expected = e.Auth.Prefix + signWebhook(e.Auth.Secret, body)
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(expected)) != 1 {
+		return nil, &SubmitError{http.StatusUnauthorized, "invalid " + e.Auth.Type, nil}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var req any
+	err = decoder.Decode(&req)
+	if err != nil {
+		return nil, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
+	}
+
+	for _, filter := range e.Filters {
+		value, found, err := evaluateMappedExpression(req, filter.Path)
+		if err != nil {
+			return nil, &SubmitError{http.StatusBadRequest, "unable to evaluate filter " + filter.Path, err}
+		}
+		matched := false
+		for _, v := range filter.Values {
+			matched = matched || (found && value == v)
+		}
+		if !matched {
+			return nil, nil
+		}
+	}
+
+	entityKey, found, err := evaluateMappedExpression(req, e.EntityKey)
+	if err != nil || !found {
+		return nil, &SubmitError{http.StatusBadRequest, "unable to extract entity key", err}
+	}
+	entityVal, found, err := evaluateMappedExpression(req, e.EntityVal)
+	if err != nil || !found {
+		return nil, &SubmitError{http.StatusBadRequest, "unable to extract entity value", err}
+	}
+	collections := map[string]string{}
+	for key, expression := range e.Collections {
+		value, found, err := evaluateMappedExpression(req, expression)
+		if err != nil {
+			return nil, &SubmitError{http.StatusBadRequest, "unable to extract collection " + key, err}
+		}
+		if found && value != "" {
+			if !slugRegex.MatchString(key) || !slugRegex.MatchString(value) {
+				return nil, &SubmitError{http.StatusBadRequest, "invalid collection " + key, nil}
+			}
+			collections[key] = value
+		}
+	}
+
+	subs := []Submission{}
+	for _, cfg := range e.Jobs {
+		sub, submitErr := HandleGenericJobConfig(cfg, entityKey, entityVal, collections)
+		if submitErr != nil {
+			return nil, submitErr
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (e *SubmitEndpointMapped) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
+	// no-op, there is no generic way to report back to the sender
+	return nil
+}
+
+// evaluateMappedExpression looks up expressions starting with $ in doc and returns all others as they are.
+// Strings, numbers and booleans are returned as text, missing fields and null as not found.
+func evaluateMappedExpression(doc any, expression string) (string, bool, error) {
+	if !strings.HasPrefix(expression, "$") {
+		return expression, true, nil
+	}
+	steps, err := parseJsonPath(expression)
+	if err != nil {
+		return "", false, err
+	}
+	value := doc
+	for _, step := range steps {
+		switch v := value.(type) {
+		case map[string]any:
+			if step.index {
+				return "", false, nil
+			}
+			value = v[step.field]
+		case []any:
+			if !step.index || step.pos < 0 || step.pos >= len(v) {
+				return "", false, nil
+			}
+			value = v[step.pos]
+		default:
+			return "", false, nil
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	case json.Number:
+		return v.String(), true, nil
+	case bool:
+		return strconv.FormatBool(v), true, nil
+	default:
+		return "", false, errors.New("value is an object or array")
+	}
+}
+
+type jsonPathStep struct {
+	field string
+	index bool
+	pos   int
+}
+
+// parseJsonPath parses the subset of JSONPath made of $ followed by .field, ['field'] and [index] steps
+func parseJsonPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("path has to start with $")
+	}
+	steps := []jsonPathStep{}
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			field := rest[1 : end+1]
+			if field == "" {
+				return nil, errors.New("empty field name")
+			}
+			steps = append(steps, jsonPathStep{field: field})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, errors.New("unterminated field name")
+			}
+			steps = append(steps, jsonPathStep{field: rest[2:end]})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, errors.New("unterminated index")
+			}
+			pos, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, errors.New("invalid index")
+			}
+			steps = append(steps, jsonPathStep{index: true, pos: pos})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected '%c'", rest[0])
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvaluateMappedExpression(t *testing.T) {
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader([]byte(`{
+		"release": {"version": "1.2.0", "build": 42, "draft": false, "assets": [{"name": "aura.zip"}], "notes": null},
+		"dotted.key": "yes"
+	}`)))
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expression string
+		value      string
+		found      bool
+		valid      bool
+	}{
+		{"literal", "literal", true, true},
+		{"$.release.version", "1.2.0", true, true},
+		{"$.release.build", "42", true, true},
+		{"$.release.draft", "false", true, true},
+		{"$.release.assets[0].name", "aura.zip", true, true},
+		{"$['dotted.key']", "yes", true, true},
+		{"$.release.assets[1].name", "", false, true},
+		{"$.release.notes", "", false, true},
+		{"$.missing.field", "", false, true},
+		{"$.release", "", false, false},
+		{"$.release.assets[x]", "", false, false},
+		{"$release", "", false, false},
+	}
+	for _, test := range tests {
+		value, found, err := evaluateMappedExpression(doc, test.expression)
+		if (err == nil) != test.valid || value != test.value || found != test.found {
+			t.Errorf("%s: expected %q %v, got %q %v %v", test.expression, test.value, test.found, value, found, err)
+		}
+	}
+}
+
+func TestSubmitEndpointMapped(t *testing.T) {
+	project := setupTestDatabase(t)
+	_, err := NewSubmitEndpointMapped(json.RawMessage(`{"auth": {"type": "none"}, "entityKey": "release", "entityVal": "$.version", "jobs": [{}]}`))
+	if err == nil {
+		t.Error("expected config without authentication to be rejected")
+	}
+	endpoint, err := NewSubmitEndpointMapped(json.RawMessage(`{
+		"auth": {"type": "hmac", "header": "X-Signature", "prefix": "sha256=", "secret": "s3cret"},
+		"entityKey": "release",
+		"entityVal": "$.release.version",
+		"collections": {"channel": "$.release.channel", "tool": "releaser"},
+		"filters": [{"path": "$.action", "values": ["published", "republished"]}],
+		"jobs": [
+			{"project": "colors", "name": "publish", "cmd": "make publish", "tag": "native,linux"},
+			{"project": "colors", "name": "announce", "cmd": "make announce", "tag": "native,linux"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		secret      string
+		payload     string
+		code        int
+		jobs        int
+		collections map[string]string
+	}{
+		{"bad signature", "wrong", `{"action": "published", "release": {"version": "1.0"}}`, http.StatusUnauthorized, 0, nil},
+		{"published", "s3cret", `{"action": "published", "release": {"version": "1.0", "channel": "stable"}}`, 0, 2, map[string]string{"channel": "stable", "tool": "releaser"}},
+		{"without channel", "s3cret", `{"action": "republished", "release": {"version": "1.0"}}`, 0, 2, map[string]string{"tool": "releaser"}},
+		{"filtered", "s3cret", `{"action": "deleted", "release": {"version": "1.0"}}`, 0, 0, nil},
+		{"missing version", "s3cret", `{"action": "published", "release": {}}`, http.StatusBadRequest, 0, nil},
+		{"invalid channel", "s3cret", `{"action": "published", "release": {"version": "1.0", "channel": "beta/2"}}`, http.StatusBadRequest, 0, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/submit/releases", bytes.NewReader([]byte(test.payload)))
+		r.Header.Set("X-Signature", "sha256="+signWebhook(test.secret, []byte(test.payload)))
+		subs, submitErr := endpoint.HandleRequest(r)
+		if test.code != 0 {
+			if submitErr == nil || submitErr.code != test.code {
+				t.Errorf("%s: expected error %d, got %v", test.name, test.code, submitErr)
+			}
+			continue
+		}
+		if submitErr != nil {
+			t.Errorf("%s: unexpected error %d %s", test.name, submitErr.code, submitErr.msg)
+			continue
+		}
+		if len(subs) != test.jobs {
+			t.Errorf("%s: expected %d submissions, got %v", test.name, test.jobs, subs)
+			continue
+		}
+		for _, sub := range subs {
+			if sub.EntityKey != "release" || sub.EntityVal != "1.0" || sub.ProjectId != project.Id || len(sub.Collections) != len(test.collections) {
+				t.Errorf("%s: unexpected submission %+v", test.name, sub)
+			}
+			for k, v := range test.collections {
+				if sub.Collections[k] != v {
+					t.Errorf("%s: expected collections %v, got %v", test.name, test.collections, sub.Collections)
+				}
+			}
+		}
+	}
+
+	token, err := NewSubmitEndpointMapped(json.RawMessage(`{
+		"auth": {"type": "token", "header": "Authorization", "secret": "Bearer abc"},
+		"entityKey": "$.kind", "entityVal": "$.id",
+		"jobs": [{"project": "colors", "name": "scan", "cmd": "make scan", "tag": "native,linux"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/submit/artifacts", bytes.NewReader([]byte(`{"kind": "artifact", "id": 17}`)))
+	r.Header.Set("Authorization", "Bearer abc")
+	subs, submitErr := token.HandleRequest(r)
+	if submitErr != nil || len(subs) != 1 || subs[0].EntityKey != "artifact" || subs[0].EntityVal != "17" {
+		t.Errorf("unexpected result %v %v", subs, submitErr)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/submit/artifacts", bytes.NewReader([]byte(`{"kind": "artifact", "id": 17}`)))
+	r.Header.Set("Authorization", "Bearer wrong")
+	_, submitErr = token.HandleRequest(r)
+	if submitErr == nil || submitErr.code != http.StatusUnauthorized {
+		t.Errorf("expected invalid token to be rejected, got %v", submitErr)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// outboxMaxAttempts is the number of failed attempts after which a status update is given up until it is retried manually
+const outboxMaxAttempts = 10
+
+// outboxInterval is how often the outbox is checked for due status updates, queued updates wake it up right away
+const outboxInterval = 5 * time.Second
+
+// outboxWakeups has a channel with room for one pending wakeup per integration, as a worker handles everything that is due anyway.
+// It is filled by StartOutbox before any requests are served and only read afterwards.
+var outboxWakeups = map[string]chan struct{}{}
+
+// queueStatusUpdates queues a status update of the entity for every integration that reports the statuses of the project.
+// Updates for the same entity are coalesced, so only the latest state is sent.
+func queueStatusUpdates(project Project, entityId int64) {
+	t := time.Now()
+	for name, endpoint := range submitEndpoints {
+		reporter, ok := endpoint.(statusReporter)
+		if !ok || !reporter.reportsStatus(project) {
+			continue
+		}
+		err := CreateStatusUpdate(name, entityId, t)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	wakeOutbox()
+}
+
+func wakeOutbox() {
+	for _, wakeup := range outboxWakeups {
+		select {
+		case wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// StartOutbox starts a worker per integration that delivers its queued status updates, including the ones left over from before a restart.
+// Every integration has its own worker, so that one that is slow or unavailable does not hold up the others.
+func StartOutbox() {
+	for name, endpoint := range submitEndpoints {
+		if _, ok := endpoint.(statusReporter); ok {
+			outboxWakeups[name] = make(chan struct{}, 1)
+		}
+	}
+	for name, wakeup := range outboxWakeups {
+		go runOutboxWorker(name, wakeup)
+	}
+}
+
+func runOutboxWorker(integration string, wakeup chan struct{}) {
+	ticker := time.NewTicker(outboxInterval)
+	for {
+		processOutbox(integration, time.Now())
+		select {
+		case <-ticker.C:
+		case <-wakeup:
+		}
+	}
+}
+
+func processOutbox(integration string, now time.Time) {
+	for {
+		updates, err := FindDueStatusUpdates(integration, now, 100)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, update := range updates {
+			deliverStatusUpdate(update, now)
+		}
+		if len(updates) < 100 {
+			return
+		}
+	}
+}
+
+func deliverStatusUpdate(update StatusUpdate, now time.Time) {
+	err := sendStatusUpdate(update)
+	if err == nil {
+		err = DeleteStatusUpdate(update.Id, update.Revision)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	log.Printf("Status update for entity %d via '%s' failed: %s\n", update.EntityId, update.Integration, err)
+	attempts := update.Attempts + 1
+	due := time.Unix(0, 0)
+	if attempts < outboxMaxAttempts {
+		due = now.Add(outboxBackoff(attempts))
+	}
+	err = MarkStatusUpdateFailed(update.Id, update.Revision, attempts, due, err.Error(), now)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func sendStatusUpdate(update StatusUpdate) error {
+	endpoint, found := submitEndpoints[update.Integration]
+	if !found {
+		return errors.New("integration is no longer configured")
+	}
+	entity, err := LoadEntity(update.EntityId)
+	if err != nil {
+		return err
+	}
+	project, err := LoadProject(entity.ProjectId)
+	if err != nil {
+		return err
+	}
+	return endpoint.UpdateEntityStatus(project, entity)
+}
+
+// outboxBackoff returns the delay before the next attempt, doubling from 10 seconds up to an hour
+func outboxBackoff(attempts int64) time.Duration {
+	delay := 10 * time.Second
+	for i := int64(1); i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
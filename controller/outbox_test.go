@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// flakyEndpoint fails the status updates until it is told to succeed
+type flakyEndpoint struct {
+	fail    bool
+	updates []int64
+}
+
+func (e *flakyEndpoint) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
+	return nil, nil
+}
+
+func (e *flakyEndpoint) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
+	if e.fail {
+		return errors.New("unavailable")
+	}
+	e.updates = append(e.updates, entity.Id)
+	return nil
+}
+
+func (e *flakyEndpoint) reportsStatus(project Project) bool {
+	return true
+}
+
+func TestOutbox(t *testing.T) {
+	project := setupTestDatabase(t)
+	endpoint := &flakyEndpoint{fail: true}
+	submitEndpoints = map[string]SubmitEndpoint{"flaky": endpoint, "": NewSubmitEndpointGeneric()}
+	t.Cleanup(func() { submitEndpoints = nil })
+	err := CreateEntity(project.Id, "commit", "abc", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := FindEntity(project.Id, "commit", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// updates for the same entity are coalesced
+	now := time.Now()
+	queueStatusUpdates(project, entity.Id)
+	queueStatusUpdates(project, entity.Id)
+	// the generic endpoint does not report statuses, so it gets no updates queued
+	generic, err := FindDueStatusUpdates("", now.Add(time.Minute), 10)
+	if err != nil || len(generic) != 0 {
+		t.Fatalf("expected no updates for the generic endpoint, got %+v %v", generic, err)
+	}
+	processOutbox("flaky", now)
+	updates, err := FindFailedStatusUpdates(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Attempts != 1 || updates[0].Error != "unavailable" || updates[0].Due.Unix() != now.Add(10*time.Second).Unix() {
+		t.Fatalf("expected one failed update due in 10 seconds, got %+v", updates)
+	}
+
+	// not retried before it is due, then with doubled delays until it is given up
+	processOutbox("flaky", now.Add(5*time.Second))
+	for attempt := int64(2); attempt <= outboxMaxAttempts; attempt++ {
+		updates, _ = FindFailedStatusUpdates(10)
+		if updates[0].Attempts != attempt-1 {
+			t.Fatalf("expected %d attempts, got %+v", attempt-1, updates[0])
+		}
+		now = updates[0].Due
+		processOutbox("flaky", now)
+	}
+	updates, _ = FindFailedStatusUpdates(10)
+	if len(updates) != 1 || updates[0].Attempts != outboxMaxAttempts || updates[0].Due.Unix() != 0 {
+		t.Fatalf("expected the update to be given up, got %+v", updates)
+	}
+	processOutbox("flaky", now.Add(24*time.Hour))
+	if len(endpoint.updates) != 0 {
+		t.Fatalf("expected no delivery, got %v", endpoint.updates)
+	}
+
+	// a manual retry delivers it and empties the outbox
+	endpoint.fail = false
+	err = MarkStatusUpdateDue(updates[0].Id, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processOutbox("flaky", now)
+	updates, _ = FindFailedStatusUpdates(10)
+	due, _ := FindDueStatusUpdates("flaky", now.Add(24*time.Hour), 10)
+	if len(updates) != 0 || len(due) != 0 || len(endpoint.updates) != 1 || endpoint.updates[0] != entity.Id {
+		t.Fatalf("expected one delivery and an empty outbox, got %v %+v %+v", endpoint.updates, updates, due)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	expected := map[int64]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 5: 160 * time.Second, 9: 2560 * time.Second, 10: time.Hour, 100: time.Hour}
+	for attempts, delay := range expected {
+		if outboxBackoff(attempts) != delay {
+			t.Errorf("expected %s after %d attempts, got %s", delay, attempts, outboxBackoff(attempts))
+		}
+	}
+}
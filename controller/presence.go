@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	PresenceNeverSeen = "never seen"
+	PresenceOnline    = "online"
+	PresenceStale     = "stale"
+	PresenceOffline   = "offline"
+)
+
+// presenceFlushInterval is how often check-ins are written to the database
+const presenceFlushInterval = 30 * time.Second
+
+// presenceStore keeps track of when runners and tags last checked in.
+// Check-ins are recorded in memory and written to the database periodically so they survive a restart.
+type presenceStore struct {
+	mutex        sync.Mutex
+	runners      map[string]time.Time
+	tags         map[string]time.Time
+	dirtyRunners map[string]bool
+	dirtyTags    map[string]bool
+}
+
+var presence = newPresenceStore()
+
+func newPresenceStore() *presenceStore {
+	return &presenceStore{
+		runners:      map[string]time.Time{},
+		tags:         map[string]time.Time{},
+		dirtyRunners: map[string]bool{},
+		dirtyTags:    map[string]bool{},
+	}
+}
+
+// Load reads the check-ins stored in the database
+func (p *presenceStore) Load() error {
+	runners, err := LoadRunnerCheckins()
+	if err != nil {
+		return err
+	}
+	tags, err := LoadTagCheckins()
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for k, v := range runners {
+		p.runners[k] = v
+	}
+	for k, v := range tags {
+		p.tags[k] = v
+	}
+	return nil
+}
+
+// CheckIn records that the runner checked in requesting jobs for the given tags
+func (p *presenceStore) CheckIn(runner string, tags []string, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.runners[runner] = now
+	p.dirtyRunners[runner] = true
+	for _, tag := range tags {
+		p.tags[tag] = now
+		p.dirtyTags[tag] = true
+	}
+}
+
+// Runner returns the last check-in of the runner
+func (p *presenceStore) Runner(name string) (time.Time, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	checkin, found := p.runners[name]
+	return checkin, found
+}
+
+// Runners returns a copy of the last check-ins of all runners
+func (p *presenceStore) Runners() map[string]time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return copyCheckins(p.runners)
+}
+
+// Tags returns a copy of the last check-ins of all tags
+func (p *presenceStore) Tags() map[string]time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return copyCheckins(p.tags)
+}
+
+// Flush writes all check-ins recorded since the last flush to the database
+func (p *presenceStore) Flush() error {
+	p.mutex.Lock()
+	runners := map[string]time.Time{}
+	for k := range p.dirtyRunners {
+		runners[k] = p.runners[k]
+	}
+	tags := map[string]time.Time{}
+	for k := range p.dirtyTags {
+		tags[k] = p.tags[k]
+	}
+	p.dirtyRunners = map[string]bool{}
+	p.dirtyTags = map[string]bool{}
+	p.mutex.Unlock()
+
+	for k, v := range runners {
+		err := UpdateRunnerCheckin(k, v)
+		if err != nil {
+			p.markDirty(runners, tags)
+			return err
+		}
+	}
+	for k, v := range tags {
+		err := UpdateTagCheckin(k, v)
+		if err != nil {
+			p.markDirty(runners, tags)
+			return err
+		}
+	}
+	return nil
+}
+
+// markDirty makes sure check-ins that could not be written are retried on the next flush
+func (p *presenceStore) markDirty(runners map[string]time.Time, tags map[string]time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for k := range runners {
+		p.dirtyRunners[k] = true
+	}
+	for k := range tags {
+		p.dirtyTags[k] = true
+	}
+}
+
+// FlushPeriodically flushes the store every presenceFlushInterval, it never returns
+func (p *presenceStore) FlushPeriodically() {
+	for {
+		time.Sleep(presenceFlushInterval)
+		err := p.Flush()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func copyCheckins(checkins map[string]time.Time) map[string]time.Time {
+	result := make(map[string]time.Time, len(checkins))
+	for k, v := range checkins {
+		result[k] = v
+	}
+	return result
+}
+
+// presenceState classifies a check-in using the configured thresholds
+func presenceState(checkin time.Time, found bool, now time.Time) string {
+	if !found {
+		return PresenceNeverSeen
+	}
+	since := now.Sub(checkin)
+	if since <= time.Duration(config.Runners.OnlineThreshold)*time.Second {
+		return PresenceOnline
+	} else if since <= time.Duration(config.Runners.OfflineThreshold)*time.Second {
+		return PresenceStale
+	} else {
+		return PresenceOffline
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -14,6 +17,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/unnamedtiger/aura/api"
 )
 
 //go:embed static/*
@@ -24,6 +29,19 @@ var templateData embed.FS
 
 var templates *template.Template
 
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
 func buildTimer(t time.Time) template.HTML {
 	tfmt := t.Format("2006-01-02 15:04:05")
 	return template.HTML(fmt.Sprintf("<span class=\"timer\" data-timer=\"%d\" title=\"%s\">%s</span>", t.Unix(), tfmt, tfmt))
@@ -54,6 +72,127 @@ func RouteRoot(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func RouteDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	webhook, err := LoadWebhook(id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	project, err := LoadProject(webhook.ProjectId)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		authOk, err := checkProjectAuth(project.Id, r.FormValue("key"))
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if !authOk {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		err = DeleteWebhook(webhook.Id)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+
+		type data struct {
+			ProjectName string
+			ProjectSlug string
+			Title       string
+			Webhook     Webhook
+		}
+		title := "Webhook deleted successfully"
+		d := data{ProjectName: project.Name, ProjectSlug: project.Slug, Title: title, Webhook: webhook}
+		err = templates.ExecuteTemplate(w, "delete-webhook-success.html", d)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	type data struct {
+		ProjectName string
+		ProjectSlug string
+		Title       string
+		Webhook     Webhook
+	}
+	title := "Delete Webhook"
+	d := data{ProjectName: project.Name, ProjectSlug: project.Slug, Title: title, Webhook: webhook}
+	err = templates.ExecuteTemplate(w, "delete-webhook.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func RouteIntegrations(w http.ResponseWriter, r *http.Request) {
+	type dataItem struct {
+		Name     string
+		Type     string
+		TypeName string
+		Url      string
+	}
+	type data struct {
+		Integrations []dataItem
+		Title        string
+	}
+	title := "Integrations"
+
+	// the listing is shown after the admin key was entered, as it reveals which repositories can submit jobs
+	if r.Method == http.MethodPost {
+		adminKey := r.FormValue("adminKey")
+		authOk, err := checkAdminAuth(adminKey)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if !authOk {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		items := []dataItem{{Name: "generic", TypeName: "Generic", Url: config.BaseUrl + "/api/v1/submit"}}
+		for _, instance := range submitEndpointInstances {
+			items = append(items, dataItem{
+				Name:     instance.Name,
+				Type:     instance.Type,
+				TypeName: submitEndpointTypes[instance.Type].Name,
+				Url:      config.BaseUrl + "/api/v1/submit/" + instance.Name,
+			})
+		}
+		d := data{Integrations: items, Title: title}
+		err = templates.ExecuteTemplate(w, "integrations.html", d)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	d := data{Title: title}
+	err := templates.ExecuteTemplate(w, "integrations.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func RouteJob(w http.ResponseWriter, r *http.Request) {
 	t := time.Now()
 	jobIdString := strings.TrimPrefix(r.URL.Path, "/j/")
@@ -97,6 +236,16 @@ func RouteJob(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	var jobLimits *api.JobLimits
+	if job.Limits != "" {
+		jobLimits = &api.JobLimits{}
+		err = json.Unmarshal([]byte(job.Limits), jobLimits)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+	}
 	jobEnvKeys := []string{}
 	for _, envVariable := range strings.Split(job.Env, "\n") {
 		if len(envVariable) > 0 {
@@ -145,14 +294,31 @@ func RouteJob(w http.ResponseWriter, r *http.Request) {
 		logContent = string(bytes)
 	}
 
+	logLines, err := loadStructuredLog(job)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	diagnostics, err := diagnoseJob(job, t)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
 	type data struct {
+		Diagnostics          api.JobDiagnosticsResponse
 		EntityKey            string
 		EntityVal            string
 		Job                  Job
 		JobDuration          string
 		JobEnvKeys           []string
+		JobLimits            *api.JobLimits
 		JobStatus            string
 		Log                  string
+		LogLines             []logLine
 		Minimal              bool
 		PrecedingJobs        []dataJob
 		ProjectName          string
@@ -162,13 +328,53 @@ func RouteJob(w http.ResponseWriter, r *http.Request) {
 		WaitingEarliestStart bool
 	}
 	title := fmt.Sprintf("Job #%d", jobId)
-	d := data{EntityKey: entity.Key, EntityVal: entity.Val, Job: job, JobDuration: jobDuration, JobEnvKeys: jobEnvKeys, JobStatus: jobStatus(job.Status), Log: logContent, Minimal: true, PrecedingJobs: precedingDataJobs, ProjectName: project.Name, ProjectSlug: project.Slug, Runner: runner, Title: title, WaitingEarliestStart: job.EarliestStart.After(t)}
+	d := data{Diagnostics: diagnostics, EntityKey: entity.Key, EntityVal: entity.Val, Job: job, JobDuration: jobDuration, JobEnvKeys: jobEnvKeys, JobLimits: jobLimits, JobStatus: jobStatus(job.Status), Log: logContent, LogLines: logLines, Minimal: true, PrecedingJobs: precedingDataJobs, ProjectName: project.Name, ProjectSlug: project.Slug, Runner: runner, Title: title, WaitingEarliestStart: job.EarliestStart.After(t)}
 	err = templates.ExecuteTemplate(w, "job.html", d)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// slowLogLine is the time after which a line in a log is highlighted as slow
+const slowLogLine = 10 * time.Second
+
+type logLine struct {
+	Clock  string
+	Delta  string
+	Line   string
+	Slow   bool
+	Stream string
+}
+
+// loadStructuredLog reads the structured log of a job if the runner uploaded one
+func loadStructuredLog(job Job) ([]logLine, error) {
+	data, err := os.ReadFile(filepath.Join("artifacts", fmt.Sprintf("%d", job.Id), "log.jsonl"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lines := []logLine{}
+	previous := job.Started
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var line api.LogLine
+		err = dec.Decode(&line)
+		if err != nil {
+			return nil, err
+		}
+		t := time.UnixMilli(line.Time)
+		delta := t.Sub(previous)
+		if delta < 0 {
+			delta = 0
+		}
+		previous = t
+		lines = append(lines, logLine{Clock: t.Format("15:04:05.000"), Delta: "+" + delta.Round(time.Millisecond).String(), Line: line.Line, Slow: delta >= slowLogLine, Stream: line.Stream})
+	}
+	return lines, nil
+}
+
 func RouteNewProject(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		name := r.FormValue("name")
@@ -194,7 +400,7 @@ func RouteNewProject(w http.ResponseWriter, r *http.Request) {
 			log.Println(err)
 			return
 		}
-		_, err = CreateProject(name, slug, hash)
+		_, err = CreateProject(name, slug, hash, time.Now())
 		if err != nil {
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			log.Println(err)
@@ -227,6 +433,99 @@ func RouteNewProject(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func RouteNewRegistrationToken(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	projects, err := LoadProjects()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		name := r.FormValue("name")
+		if !slugRegex.MatchString(name) {
+			http.Error(w, "invalid name", http.StatusBadRequest)
+			return
+		}
+		project := Project{}
+		projectSlug := r.FormValue("project")
+		if projectSlug != "" {
+			project, err = FindProjectBySlug(projectSlug)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					http.Error(w, "invalid project", http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+		}
+		singleUse := r.FormValue("singleUse") != ""
+		expires := time.Time{}
+		expiresString := r.FormValue("expires")
+		if expiresString != "" {
+			hours, err := strconv.ParseInt(expiresString, 10, 64)
+			if err != nil || hours <= 0 {
+				http.Error(w, "invalid expiry", http.StatusBadRequest)
+				return
+			}
+			expires = t.Add(time.Duration(hours) * time.Hour)
+		}
+		adminKey := r.FormValue("adminKey")
+		authOk, err := checkAdminAuth(adminKey)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if !authOk {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		pass, hash, err := GenerateRandom(PrefixRegistration)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		_, err = CreateRegistrationToken(name, hash, project.Id, singleUse, expires, t)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+
+		type data struct {
+			Expires         time.Time
+			Name            string
+			ProjectName     string
+			RegistrationKey string
+			SingleUse       bool
+			Title           string
+		}
+		title := "New Registration Token created successfully"
+		d := data{Expires: expires, Name: name, ProjectName: project.Name, RegistrationKey: pass, SingleUse: singleUse, Title: title}
+		err = templates.ExecuteTemplate(w, "new-registration-token-success.html", d)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	type data struct {
+		Projects []Project
+		Title    string
+	}
+	title := "Create New Registration Token"
+	d := data{Projects: projects, Title: title}
+	err = templates.ExecuteTemplate(w, "new-registration-token.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func RouteNewRunner(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		name := r.FormValue("name")
@@ -251,8 +550,11 @@ func RouteNewRunner(w http.ResponseWriter, r *http.Request) {
 			log.Println(err)
 			return
 		}
-		_, err = CreateRunner(name, hash)
-		if err != nil {
+		_, err = CreateRunner(name, hash, 0, 0, time.Now())
+		if errors.Is(err, ErrExists) {
+			http.Error(w, "runner already exists", http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			log.Println(err)
 			return
@@ -285,6 +587,189 @@ func RouteNewRunner(w http.ResponseWriter, r *http.Request) {
 
 var slugRegex = regexp.MustCompile(`^[0-9A-Za-z-_:\.]{1,260}$`)
 
+func RouteNewWebhook(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
+	projectSlug := r.FormValue("project")
+	if !slugRegex.MatchString(projectSlug) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	project, err := FindProjectBySlug(projectSlug)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		webhookUrl, err := url.Parse(r.FormValue("url"))
+		if err != nil || (webhookUrl.Scheme != "http" && webhookUrl.Scheme != "https") || webhookUrl.Host == "" {
+			http.Error(w, "invalid url", http.StatusBadRequest)
+			return
+		}
+		events := []string{}
+		for _, event := range api.WebhookEvents {
+			if r.FormValue(event) != "" {
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			http.Error(w, "no events selected", http.StatusBadRequest)
+			return
+		}
+		authOk, err := checkProjectAuth(project.Id, r.FormValue("key"))
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if !authOk {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		secret := r.FormValue("secret")
+		if secret == "" {
+			secret, err = generateWebhookSecret()
+			if err != nil {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+		}
+		_, err = CreateWebhook(project.Id, webhookUrl.String(), secret, events, t)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+
+		type data struct {
+			Events      []string
+			ProjectName string
+			ProjectSlug string
+			Secret      string
+			Title       string
+			Url         string
+		}
+		title := "New Webhook created successfully"
+		d := data{Events: events, ProjectName: project.Name, ProjectSlug: project.Slug, Secret: secret, Title: title, Url: webhookUrl.String()}
+		err = templates.ExecuteTemplate(w, "new-webhook-success.html", d)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	type data struct {
+		Events      []string
+		ProjectName string
+		ProjectSlug string
+		Title       string
+	}
+	title := "Create New Webhook"
+	d := data{Events: api.WebhookEvents, ProjectName: project.Name, ProjectSlug: project.Slug, Title: title}
+	err = templates.ExecuteTemplate(w, "new-webhook.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func RouteOutbox(w http.ResponseWriter, r *http.Request) {
+	type dataItem struct {
+		EntityUrl    string
+		EntityName   string
+		GivenUp      bool
+		ProjectName  string
+		StatusUpdate StatusUpdate
+	}
+	type data struct {
+		Authed  bool
+		Updates []dataItem
+		Title   string
+	}
+	title := "Status Outbox"
+
+	// the admin key is not put into the page, every retry asks for it again
+	if r.Method == http.MethodPost {
+		adminKey := r.FormValue("adminKey")
+		authOk, err := checkAdminAuth(adminKey)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if !authOk {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		retry := r.FormValue("retry")
+		if retry != "" {
+			id, err := strconv.ParseInt(retry, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			err = MarkStatusUpdateDue(id, time.Now())
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+			wakeOutbox()
+		}
+
+		updates, err := FindFailedStatusUpdates(100)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		projects := map[int64]Project{}
+		items := []dataItem{}
+		for _, update := range updates {
+			entity, err := LoadEntity(update.EntityId)
+			if err != nil {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+			project, found := projects[entity.ProjectId]
+			if !found {
+				project, err = LoadProject(entity.ProjectId)
+				if err != nil {
+					http.Error(w, "internal server error", http.StatusInternalServerError)
+					log.Println(err)
+					return
+				}
+				projects[entity.ProjectId] = project
+			}
+			items = append(items, dataItem{
+				EntityUrl:    fmt.Sprintf("/p/%s/%s/%s", project.Slug, entity.Key, entity.Val),
+				EntityName:   entity.Key + "/" + entity.Val,
+				GivenUp:      update.Due.Unix() == 0,
+				ProjectName:  project.Name,
+				StatusUpdate: update,
+			})
+		}
+		d := data{Authed: true, Updates: items, Title: title}
+		err = templates.ExecuteTemplate(w, "outbox.html", d)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	d := data{Title: title}
+	err := templates.ExecuteTemplate(w, "outbox.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func RouteProject(w http.ResponseWriter, r *http.Request) {
 	p := strings.TrimPrefix(r.URL.Path, "/p/")
 	slashes := strings.Count(p, "/")
@@ -337,7 +822,7 @@ func RouteProjectKey(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	limit := 10
-	eocList, err := FindEntitiesOrCollections(project.Id, entityKey, before, int64(limit+1))
+	eocList, _, err := FindEntitiesOrCollections(project.Id, entityKey, before, int64(limit+1))
 	if err != nil {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		log.Println(err)
@@ -563,7 +1048,7 @@ func RouteProjectMain(w http.ResponseWriter, r *http.Request) {
 	eocMore := map[string]bool{}
 	limit := 10
 	for _, eocKey := range eocKeys {
-		eocList, err := FindEntitiesOrCollections(project.Id, eocKey, 0, int64(limit+1))
+		eocList, _, err := FindEntitiesOrCollections(project.Id, eocKey, 0, int64(limit+1))
 		if err != nil {
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			log.Println(err)
@@ -575,24 +1060,100 @@ func RouteProjectMain(w http.ResponseWriter, r *http.Request) {
 		}
 		eocs[eocKey] = eocList
 	}
+	webhooks, err := FindWebhooksByProjectId(project.Id)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	deliveries, err := FindWebhookDeliveriesByProjectId(project.Id, 20)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
 
 	type data struct {
+		Deliveries  []WebhookDelivery
 		Entities    map[string][]EntityOrCollection
 		EntityKeys  []string
 		EntityMore  map[string]bool
 		ProjectName string
 		ProjectSlug string
 		Title       string
+		Webhooks    []Webhook
 	}
 	title := project.Name
-	d := data{Entities: eocs, EntityKeys: eocKeys, EntityMore: eocMore, ProjectName: project.Name, ProjectSlug: project.Slug, Title: title}
+	d := data{Deliveries: deliveries, Entities: eocs, EntityKeys: eocKeys, EntityMore: eocMore, ProjectName: project.Name, ProjectSlug: project.Slug, Title: title, Webhooks: webhooks}
 	err = templates.ExecuteTemplate(w, "project.html", d)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// queueGroup contains the queued jobs with one tag
+type queueGroup struct {
+	QueuedTag
+	Jobs          []Job
+	Older         int64
+	OnlineRunners int
+	Starving      bool
+}
+
+// loadQueue groups the queued jobs by tag, only the jobs with tagFilter are included if it is set.
+// Also returns the number of starving tags, ignoring tagFilter.
+func loadQueue(tagFilter string, before int64, limit int, now time.Time) ([]queueGroup, int, error) {
+	queuedTags, err := FindQueuedTags()
+	if err != nil {
+		return nil, 0, err
+	}
+	runners, err := LoadRunners()
+	if err != nil {
+		return nil, 0, err
+	}
+	runnerCheckins := presence.Runners()
+	onlineRunners := map[string]int{}
+	for _, runner := range runners {
+		checkin, found := runnerCheckins[runner.Name]
+		if presenceState(checkin, found, now) != PresenceOnline {
+			continue
+		}
+		for _, tag := range runner.Tags {
+			onlineRunners[tag]++
+		}
+	}
+	tagCheckins := presence.Tags()
+
+	groups := []queueGroup{}
+	starving := 0
+	for _, queuedTag := range queuedTags {
+		tagCheckin, found := tagCheckins[queuedTag.Tag]
+		isStarving := onlineRunners[queuedTag.Tag] == 0 && presenceState(tagCheckin, found, now) != PresenceOnline
+		if isStarving {
+			starving++
+		}
+		if tagFilter != "" && queuedTag.Tag != tagFilter {
+			continue
+		}
+		jobs, err := FindQueuedJobs(queuedTag.Tag, before, int64(limit+1))
+		if err != nil {
+			return nil, 0, err
+		}
+		more := len(jobs) > limit
+		if len(jobs) > limit {
+			jobs = jobs[:limit]
+		}
+		older := int64(0)
+		if more {
+			older = jobs[len(jobs)-1].Created.Unix()
+		}
+		groups = append(groups, queueGroup{QueuedTag: queuedTag, Jobs: jobs, Older: older, OnlineRunners: onlineRunners[queuedTag.Tag], Starving: isStarving})
+	}
+	return groups, starving, nil
+}
+
 func RouteQueue(w http.ResponseWriter, r *http.Request) {
+	t := time.Now()
 	query := r.URL.Query()
 	before := int64(0)
 	if query.Has("before") {
@@ -604,21 +1165,14 @@ func RouteQueue(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	limit := 10
-	jobs, err := FindQueuedJobs(before, int64(limit+1))
+	tagFilter := query.Get("tag")
+
+	queue, starving, err := loadQueue(tagFilter, before, 10, t)
 	if err != nil {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
-	more := len(jobs) > limit
-	if len(jobs) > limit {
-		jobs = jobs[:limit]
-	}
-	older := int64(0)
-	if more {
-		older = jobs[len(jobs)-1].Created.Unix()
-	}
 
 	type dataJob struct {
 		Job         Job
@@ -626,28 +1180,281 @@ func RouteQueue(w http.ResponseWriter, r *http.Request) {
 		JobStatus   string
 		Minimal     bool
 	}
-	dataJobs := []dataJob{}
-	for _, job := range jobs {
-		dataJobs = append(dataJobs, dataJob{Job: job, JobDuration: "", JobStatus: jobStatus(job.Status), Minimal: false})
+	type dataGroup struct {
+		Count         int64
+		Jobs          []dataJob
+		Older         int64
+		Oldest        time.Time
+		OnlineRunners int
+		Starving      bool
+		Tag           string
+	}
+	groups := []dataGroup{}
+	for _, group := range queue {
+		dataJobs := []dataJob{}
+		for _, job := range group.Jobs {
+			dataJobs = append(dataJobs, dataJob{Job: job, JobDuration: "", JobStatus: jobStatus(job.Status), Minimal: false})
+		}
+		groups = append(groups, dataGroup{Count: group.Count, Jobs: dataJobs, Older: group.Older, Oldest: group.Oldest, OnlineRunners: group.OnlineRunners, Starving: group.Starving, Tag: group.Tag})
 	}
 
 	type data struct {
-		Jobs  []dataJob
-		Older int64
-		Title string
+		Groups    []dataGroup
+		Starving  int
+		TagFilter string
+		Title     string
 	}
 	title := "Queued Jobs"
-	d := data{Jobs: dataJobs, Older: older, Title: title}
+	d := data{Groups: groups, Starving: starving, TagFilter: tagFilter, Title: title}
 	err = templates.ExecuteTemplate(w, "queue.html", d)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+func RouteRunner(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/r/")
+	if !slugRegex.MatchString(name) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	runner, err := FindRunnerByName(name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	t := time.Now()
+	query := r.URL.Query()
+	before := int64(0)
+	if query.Has("before") {
+		beforeString := query.Get("before")
+		before, err = strconv.ParseInt(beforeString, 10, 64)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+	}
+	checkin, checkedIn := presence.Runner(runner.Name)
+	registration, err := loadRunnerRegistration(runner)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	type dataJob struct {
+		Job         Job
+		JobDuration string
+		JobStatus   string
+		Minimal     bool
+	}
+	entities := map[int64]EntityOrCollection{}
+	projects := map[int64]Project{}
+	buildDataJob := func(job Job) (dataJob, error) {
+		entity, found := entities[job.EntityId]
+		if !found {
+			entity, err = LoadEntity(job.EntityId)
+			if err != nil {
+				return dataJob{}, err
+			}
+			entities[job.EntityId] = entity
+		}
+		project, found := projects[entity.ProjectId]
+		if !found {
+			project, err = LoadProject(entity.ProjectId)
+			if err != nil {
+				return dataJob{}, err
+			}
+			projects[entity.ProjectId] = project
+		}
+		jobDuration := ""
+		if job.Status == StatusSucceeded || job.Status == StatusFailed {
+			jobDuration = job.Ended.Sub(job.Started).String()
+		}
+		job.Name = fmt.Sprintf("%s / %s / %s / %s", project.Name, entity.Key, entity.Val, job.Name)
+		return dataJob{Job: job, JobDuration: jobDuration, JobStatus: jobStatus(job.Status), Minimal: false}, nil
+	}
+
+	startedJobs, err := FindStartedJobsByRunner(runner.Id)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	currentJobs := []dataJob{}
+	for _, job := range startedJobs {
+		item, err := buildDataJob(job)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		currentJobs = append(currentJobs, item)
+	}
+
+	limit := 10
+	finishedJobs, err := FindJobsByRunner(runner.Id, before, int64(limit+1))
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	more := len(finishedJobs) > limit
+	if len(finishedJobs) > limit {
+		finishedJobs = finishedJobs[:limit]
+	}
+	older := int64(0)
+	if more {
+		older = finishedJobs[len(finishedJobs)-1].Started.Unix()
+	}
+	recentJobs := []dataJob{}
+	for _, job := range finishedJobs {
+		item, err := buildDataJob(job)
+		if err != nil {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		recentJobs = append(recentJobs, item)
+	}
+
+	counts, err := CountJobsByRunner(runner.Id)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	type dataRates struct {
+		Failed        int64
+		FailedRate    string
+		Succeeded     int64
+		SucceededRate string
+	}
+	rates := dataRates{Failed: counts[StatusFailed], Succeeded: counts[StatusSucceeded]}
+	if total := rates.Failed + rates.Succeeded; total > 0 {
+		rates.FailedRate = fmt.Sprintf("%.1f%%", float64(rates.Failed)*100/float64(total))
+		rates.SucceededRate = fmt.Sprintf("%.1f%%", float64(rates.Succeeded)*100/float64(total))
+	}
+
+	type dataTag struct {
+		CheckedIn bool
+		Date      time.Time
+		Name      string
+		Presence  string
+	}
+	tagCheckins := presence.Tags()
+	tags := []dataTag{}
+	for _, tag := range runner.Tags {
+		tagCheckin, found := tagCheckins[tag]
+		tags = append(tags, dataTag{CheckedIn: found, Date: tagCheckin, Name: tag, Presence: presenceState(tagCheckin, found, t)})
+	}
+
+	type data struct {
+		CheckedIn         bool
+		Checkin           time.Time
+		CompatibilityMode bool
+		CurrentJobs       []dataJob
+		HasInfo           bool
+		LowDisk           bool
+		Older             int64
+		Presence          string
+		Rates             dataRates
+		RecentJobs        []dataJob
+		Registration      runnerRegistration
+		Runner            Runner
+		Tags              []dataTag
+		Title             string
+	}
+	title := fmt.Sprintf("Runner %s", runner.Name)
+	d := data{CheckedIn: checkedIn, Checkin: checkin, CompatibilityMode: checkedIn && compatibilityMode(runner.Protocol), CurrentJobs: currentJobs, HasInfo: runner.InfoUpdated.Unix() > 0, LowDisk: isLowDisk(runner), Older: older, Presence: presenceState(checkin, checkedIn, t), Rates: rates, RecentJobs: recentJobs, Registration: registration, Runner: runner, Tags: tags, Title: title}
+	err = templates.ExecuteTemplate(w, "runner.html", d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// runnerRegistration describes how a runner was created and which jobs it may receive
+type runnerRegistration struct {
+	ProjectName string
+	ProjectSlug string
+	TokenName   string
+}
+
+func loadRunnerRegistration(runner Runner) (runnerRegistration, error) {
+	registration := runnerRegistration{}
+	if runner.ProjectId > 0 {
+		project, err := LoadProject(runner.ProjectId)
+		if err != nil {
+			return runnerRegistration{}, err
+		}
+		registration.ProjectName = project.Name
+		registration.ProjectSlug = project.Slug
+	}
+	if runner.RegistrationTokenId > 0 {
+		tokens, err := LoadRegistrationTokens()
+		if err != nil {
+			return runnerRegistration{}, err
+		}
+		for _, token := range tokens {
+			if token.Id == runner.RegistrationTokenId {
+				registration.TokenName = token.Name
+			}
+		}
+	}
+	return registration, nil
+}
+
+// isLowDisk reports whether the runner reported less free disk space than configured
+func isLowDisk(runner Runner) bool {
+	return runner.FreeDisk > 0 && runner.FreeDisk < config.Runners.LowDiskSpace
+}
+
 func RouteRunners(w http.ResponseWriter, r *http.Request) {
 	type dataItem struct {
-		Name string
-		Date time.Time
+		Name     string
+		Date     time.Time
+		Presence string
+	}
+	type dataRunner struct {
+		CompatibilityMode bool
+		Date              time.Time
+		LowDisk           bool
+		Registration      runnerRegistration
+		Runner            Runner
+	}
+	type dataToken struct {
+		ProjectName string
+		Token       RegistrationToken
+		Usable      bool
+	}
+	t := time.Now()
+
+	projects, err := LoadProjects()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	projectsById := map[int64]Project{}
+	for _, project := range projects {
+		projectsById[project.Id] = project
+	}
+	tokensData, err := LoadRegistrationTokens()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	tokens := []dataToken{}
+	tokenNames := map[int64]string{}
+	for _, token := range tokensData {
+		tokens = append(tokens, dataToken{ProjectName: projectsById[token.ProjectId].Name, Token: token, Usable: token.Usable(t)})
+		tokenNames[token.Id] = token.Name
 	}
 
 	runnersData, err := LoadRunners()
@@ -656,17 +1463,17 @@ func RouteRunners(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	runners := []dataItem{}
-	offlineRunners := []string{}
+	runnerCheckins := presence.Runners()
+	runners := map[string][]dataRunner{}
 	for _, runnerData := range runnersData {
+		project := projectsById[runnerData.ProjectId]
+		registration := runnerRegistration{ProjectName: project.Name, ProjectSlug: project.Slug, TokenName: tokenNames[runnerData.RegistrationTokenId]}
 		checkin, found := runnerCheckins[runnerData.Name]
-		if found {
-			runners = append(runners, dataItem{Name: runnerData.Name, Date: checkin})
-		} else {
-			offlineRunners = append(offlineRunners, runnerData.Name)
-		}
+		state := presenceState(checkin, found, t)
+		runners[state] = append(runners[state], dataRunner{CompatibilityMode: found && compatibilityMode(runnerData.Protocol), Date: checkin, LowDisk: isLowDisk(runnerData), Registration: registration, Runner: runnerData})
 	}
 
+	tagCheckins := presence.Tags()
 	tags := []dataItem{}
 	tagNames := make([]string, 0, len(tagCheckins))
 	for k := range tagCheckins {
@@ -674,17 +1481,20 @@ func RouteRunners(w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Strings(tagNames)
 	for _, tagName := range tagNames {
-		tags = append(tags, dataItem{Name: tagName, Date: tagCheckins[tagName]})
+		tags = append(tags, dataItem{Name: tagName, Date: tagCheckins[tagName], Presence: presenceState(tagCheckins[tagName], true, t)})
 	}
 
 	type data struct {
-		Runners        []dataItem
-		OfflineRunners []string
-		Tags           []dataItem
-		Title          string
+		NeverSeenRunners   []dataRunner
+		OfflineRunners     []dataRunner
+		RegistrationTokens []dataToken
+		Runners            []dataRunner
+		StaleRunners       []dataRunner
+		Tags               []dataItem
+		Title              string
 	}
 	title := "Runner Status"
-	d := data{Runners: runners, OfflineRunners: offlineRunners, Tags: tags, Title: title}
+	d := data{NeverSeenRunners: runners[PresenceNeverSeen], OfflineRunners: runners[PresenceOffline], RegistrationTokens: tokens, Runners: runners[PresenceOnline], StaleRunners: runners[PresenceStale], Tags: tags, Title: title}
 	err = templates.ExecuteTemplate(w, "runners.html", d)
 	if err != nil {
 		log.Println(err)
@@ -2,10 +2,7 @@ package main
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
 	"crypto/subtle"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,7 +10,6 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"sort"
 	"strings"
 	"time"
@@ -34,74 +30,102 @@ type SubmitError struct {
 }
 
 type SubmitEndpoint interface {
-	HandleRequest(r *http.Request) (Submission, *SubmitError)
-	UpdateEntityStatus(project Project, entity EntityOrCollection)
+	// HandleRequest returns the jobs to submit for the request, events that do not lead to jobs return none
+	HandleRequest(r *http.Request) ([]Submission, *SubmitError)
+	// UpdateEntityStatus reports the status of the entity back, failed updates are retried by the outbox
+	UpdateEntityStatus(project Project, entity EntityOrCollection) error
 }
 
-var submitEndpoints map[string]SubmitEndpoint
+// statusReporter is implemented by the endpoints that report statuses back, only they get status updates queued
+type statusReporter interface {
+	// reportsStatus returns whether the endpoint reports the statuses of the project
+	reportsStatus(project Project) bool
+}
+
+// submitEndpointType creates the endpoints of one kind of integration
+type submitEndpointType struct {
+	Name string
+	New  func(auraBaseUrl string, cfgData json.RawMessage) (SubmitEndpoint, error)
+}
+
+// submitEndpointTypes contains every integration that can be configured, keyed by its ID
+var submitEndpointTypes = map[string]submitEndpointType{
+	"darke": {"Darke", func(auraBaseUrl string, cfgData json.RawMessage) (SubmitEndpoint, error) {
+		return NewSubmitEndpointDarke(auraBaseUrl, cfgData)
+	}},
+	"gitea": {"Gitea", func(auraBaseUrl string, cfgData json.RawMessage) (SubmitEndpoint, error) {
+		return NewSubmitEndpointGitea(auraBaseUrl, cfgData)
+	}},
+	"github": {"GitHub", func(auraBaseUrl string, cfgData json.RawMessage) (SubmitEndpoint, error) {
+		return NewSubmitEndpointGithub(auraBaseUrl, cfgData)
+	}},
+	"gitlab": {"GitLab", func(auraBaseUrl string, cfgData json.RawMessage) (SubmitEndpoint, error) {
+		return NewSubmitEndpointGitlab(auraBaseUrl, cfgData)
+	}},
+	"mapped": {"Mapped", func(auraBaseUrl string, cfgData json.RawMessage) (SubmitEndpoint, error) {
+		return NewSubmitEndpointMapped(cfgData)
+	}},
+}
 
-type GeneralConfig struct {
-	BaseUrl string `json:"baseUrl"`
+// submitEndpointInstance describes a configured integration for the integrations page
+type submitEndpointInstance struct {
+	Name string
+	Type string
 }
 
+var submitEndpoints map[string]SubmitEndpoint
+var submitEndpointInstances []submitEndpointInstance
+
 func InitializeSubmitEndpoints() {
-	var gcfg GeneralConfig
-	var cfg map[string]json.RawMessage
 	submitEndpoints = map[string]SubmitEndpoint{}
-
-	_, err := os.Stat("config.json")
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			log.Fatalln(err)
-		}
-	} else {
-		data, err := os.ReadFile("config.json")
-		if err != nil {
-			log.Fatalln(err)
-		}
-		err = json.Unmarshal(data, &gcfg)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		err = json.Unmarshal(data, &cfg)
-		if err != nil {
-			log.Fatalln(err)
-		}
-	}
-	baseUrl, err := url.Parse(gcfg.BaseUrl)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	gcfg.BaseUrl = fmt.Sprintf("%s://%s", baseUrl.Scheme, baseUrl.Host)
+	submitEndpointInstances = []submitEndpointInstance{}
 
 	submitEndpoints[""] = NewSubmitEndpointGeneric()
-	log.Printf("Initialized generic submit endpoint at /api/submit\n")
+	log.Printf("Initialized generic submit endpoint at /api/v1/submit\n")
 
-	cfgKeys := make([]string, 0, len(cfg))
-	for k := range cfg {
+	cfgKeys := make([]string, 0, len(integrationConfigs))
+	for k := range integrationConfigs {
 		cfgKeys = append(cfgKeys, k)
 	}
 	sort.Strings(cfgKeys)
 
 	for _, cfgKey := range cfgKeys {
-		if cfgKey == "baseUrl" {
-			continue
-		} else if cfgKey == "darke" {
-			submitEndpoints["darke"], err = NewSubmitEndpointDarke(cfg["darke"])
-			if err != nil {
-				log.Fatalln(err)
-			}
-			log.Printf("Initialized submit endpoint for Darke at /api/submit/darke\n")
-		} else if cfgKey == "gitea" {
-			submitEndpoints["gitea"], err = NewSubmitEndpointGitea(gcfg.BaseUrl, cfg["gitea"])
-			if err != nil {
-				log.Fatalln(err)
-			}
-			log.Printf("Initialized submit endpoint for Gitea at /api/submit/gitea\n")
-		} else {
-			log.Fatalf("Unknown integration '%s'\n", cfgKey)
+		endpoint, typ, err := newSubmitEndpoint(cfgKey, integrationConfigs[cfgKey])
+		if err != nil {
+			log.Fatalf("Integration '%s': %s\n", cfgKey, err)
 		}
+		submitEndpoints[cfgKey] = endpoint
+		submitEndpointInstances = append(submitEndpointInstances, submitEndpointInstance{Name: cfgKey, Type: typ})
+		log.Printf("Initialized submit endpoint for %s at /api/v1/submit/%s\n", submitEndpointTypes[typ].Name, cfgKey)
+	}
+}
+
+// newSubmitEndpoint creates the integration configured under name and returns it with its type.
+// The type is taken from the "type" field of the config and defaults to the name,
+// so that a single instance of each integration can be configured under its ID.
+func newSubmitEndpoint(name string, cfgData json.RawMessage) (SubmitEndpoint, string, error) {
+	if !slugRegex.MatchString(name) {
+		return nil, "", errors.New("invalid name")
+	}
+	var cfg struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(cfgData, &cfg)
+	if err != nil {
+		return nil, "", err
 	}
+	if cfg.Type == "" {
+		cfg.Type = name
+	}
+	typ, found := submitEndpointTypes[cfg.Type]
+	if !found {
+		return nil, "", fmt.Errorf("unknown type '%s'", cfg.Type)
+	}
+	endpoint, err := typ.New(config.BaseUrl, cfgData)
+	if err != nil {
+		return nil, "", err
+	}
+	return endpoint, cfg.Type, nil
 }
 
 func Submit(sub Submission) (int64, *SubmitError) {
@@ -109,6 +133,18 @@ func Submit(sub Submission) (int64, *SubmitError) {
 	if !slugRegex.MatchString(sub.Name) {
 		return 0, &SubmitError{http.StatusBadRequest, "invalid name", nil}
 	}
+	if sub.Cache != nil {
+		err := sub.Cache.Validate()
+		if err != nil {
+			return 0, &SubmitError{http.StatusBadRequest, err.Error(), nil}
+		}
+	}
+	if sub.Limits != nil {
+		err := sub.Limits.Validate()
+		if err != nil {
+			return 0, &SubmitError{http.StatusBadRequest, err.Error(), nil}
+		}
+	}
 
 	entity, err := FindEntity(sub.ProjectId, sub.EntityKey, sub.EntityVal)
 	if err != nil {
@@ -136,7 +172,23 @@ func Submit(sub Submission) (int64, *SubmitError) {
 	if sub.EarliestStart != nil {
 		earliestStart = time.Unix(*sub.EarliestStart, 0)
 	}
-	jobId, err := CreateJob(entity.Id, sub.Name, t, earliestStart, sub.Cmd, sub.Env, sub.Tag)
+	cache := ""
+	if sub.Cache != nil {
+		cacheData, err := json.Marshal(sub.Cache)
+		if err != nil {
+			return 0, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		}
+		cache = string(cacheData)
+	}
+	limits := ""
+	if sub.Limits != nil {
+		limitsData, err := json.Marshal(sub.Limits)
+		if err != nil {
+			return 0, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		}
+		limits = string(limitsData)
+	}
+	jobId, err := CreateJob(entity.Id, sub.Name, t, earliestStart, sub.Cmd, sub.Env, sub.Tag, cache, limits)
 	if err != nil {
 		return 0, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 	}
@@ -198,11 +250,12 @@ func submitPostprocessing(sub Submission, jobId int64, t time.Time, entityId int
 				log.Println(err)
 				return
 			}
-			err = MarkJobDone(jobId, StatusCancelled, 0, t)
+			err = MarkJobDone(jobId, StatusCancelled, 0, "", t)
 			if err != nil {
 				log.Println(err)
 				return
 			}
+			notifyJobStatus(jobId)
 		} else if precedingJob.Status == StatusSucceeded {
 			err = MarkPrecedingJobCompleted(precedingJob.Id)
 			if err != nil {
@@ -217,6 +270,7 @@ func submitPostprocessing(sub Submission, jobId int64, t time.Time, entityId int
 		log.Println(err)
 		return
 	}
+	notifyJobStatus(jobId)
 
 	UpdateEntityStatus(entityId)
 }
@@ -233,27 +287,36 @@ func RouteApiSubmit(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusNotFound, "invalid submission endpoint")
 		return
 	}
-	sub, err := endpoint.HandleRequest(r)
+	subs, err := endpoint.HandleRequest(r)
 	if err != nil {
 		log.Printf("%d %s %s\n", err.code, err.msg, err.err)
 		respondError(w, err.code, err.msg)
 		return
 	}
-	jobId, err := Submit(sub)
-	if err != nil {
-		log.Printf("%d %s %s\n", err.code, err.msg, err.err)
-		respondError(w, err.code, err.msg)
-		return
+	resp := api.SubmitResponse{Ids: []int64{}}
+	for _, sub := range subs {
+		jobId, err := Submit(sub)
+		if err != nil {
+			log.Printf("%d %s %s\n", err.code, err.msg, err.err)
+			respondError(w, err.code, err.msg)
+			return
+		}
+		if resp.Id == 0 {
+			resp.Id = jobId
+		}
+		resp.Ids = append(resp.Ids, jobId)
 	}
-	respond(w, http.StatusOK, api.SubmitResponse{Id: jobId})
+	respond(w, http.StatusOK, resp)
 }
 
 type GenericJobConfig struct {
-	Project string `json:"project"`
-	Name    string `json:"name"`
-	Cmd     string `json:"cmd"`
-	Env     string `json:"env"`
-	Tag     string `json:"tag"`
+	Project string         `json:"project"`
+	Name    string         `json:"name"`
+	Cmd     string         `json:"cmd"`
+	Env     string         `json:"env"`
+	Tag     string         `json:"tag"`
+	Cache   *api.JobCache  `json:"cache"`
+	Limits  *api.JobLimits `json:"limits"`
 }
 
 func HandleGenericJobConfig(cfg GenericJobConfig, entityKey string, entityVal string, collections map[string]string) (Submission, *SubmitError) {
@@ -275,6 +338,8 @@ func HandleGenericJobConfig(cfg GenericJobConfig, entityKey string, entityVal st
 			Env:         cfg.Env,
 			Tag:         cfg.Tag,
 			Collections: collections,
+			Cache:       cfg.Cache,
+			Limits:      cfg.Limits,
 		},
 		ProjectId: project.Id,
 	}, nil
@@ -291,8 +356,21 @@ func UpdateEntityStatus(entityId int64) {
 		log.Println(err)
 		return
 	}
-	for _, endpoint := range submitEndpoints {
-		endpoint.UpdateEntityStatus(project, entity)
+	queueStatusUpdates(project, entity.Id)
+
+	description, state, err := getEntityStatus(entity.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	changed, err := UpdateEntityState(entity.Id, state, time.Now())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if changed {
+		notifyEntityState(project, entity, state, description)
+		notifyByEmail(project, entity, state)
 	}
 }
 
@@ -350,7 +428,7 @@ func getEntityStatus(entityId int64) (string, string, error) {
 }
 
 // =============================================================================
-// /api/submit
+// /api/v1/submit
 // =============================================================================
 
 type SubmitEndpointGeneric struct {
@@ -360,27 +438,27 @@ func NewSubmitEndpointGeneric() SubmitEndpointGeneric {
 	return SubmitEndpointGeneric{}
 }
 
-func (SubmitEndpointGeneric) HandleRequest(r *http.Request) (Submission, *SubmitError) {
+func (SubmitEndpointGeneric) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 	}
 	var req api.SubmitRequest
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
+		return nil, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
 	}
 	project, err := FindProjectBySlug(req.Project)
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
-			return Submission{}, &SubmitError{http.StatusBadRequest, "unknown project", err}
+			return nil, &SubmitError{http.StatusBadRequest, "unknown project", err}
 		}
-		return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 	}
 
 	authHeader := r.Header.Get("Authorization")
 	if len(authHeader) == 0 {
-		return Submission{}, &SubmitError{http.StatusUnauthorized, "missing authorization header", nil}
+		return nil, &SubmitError{http.StatusUnauthorized, "missing authorization header", nil}
 	}
 	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
 	authOk := false
@@ -388,62 +466,78 @@ func (SubmitEndpointGeneric) HandleRequest(r *http.Request) (Submission, *Submit
 		parentJob, err := LoadJob(*req.ParentJob)
 		if err != nil {
 			if errors.Is(err, ErrNotFound) {
-				return Submission{}, &SubmitError{http.StatusBadRequest, "unknown parent job", err}
+				return nil, &SubmitError{http.StatusBadRequest, "unknown parent job", err}
 			}
-			return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+			return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 		}
 		parentEntity, err := LoadEntity(parentJob.EntityId)
 		if err != nil {
-			return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+			return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 		}
 		if project.Id != parentEntity.ProjectId {
-			return Submission{}, &SubmitError{http.StatusUnauthorized, "project does not match parent job project", nil}
+			return nil, &SubmitError{http.StatusUnauthorized, "project does not match parent job project", nil}
 		}
 		if req.EntityKey != parentEntity.Key {
-			return Submission{}, &SubmitError{http.StatusUnauthorized, "entityKey does not match parent job entityKey", nil}
+			return nil, &SubmitError{http.StatusUnauthorized, "entityKey does not match parent job entityKey", nil}
 		}
 		if req.EntityVal != parentEntity.Val {
-			return Submission{}, &SubmitError{http.StatusUnauthorized, "entityVal does not match parent job entityVal", nil}
+			return nil, &SubmitError{http.StatusUnauthorized, "entityVal does not match parent job entityVal", nil}
 		}
 		if len(req.Collections) > 0 {
-			return Submission{}, &SubmitError{http.StatusUnauthorized, "may not set collections when using JOBKEY", nil}
+			return nil, &SubmitError{http.StatusUnauthorized, "may not set collections when using JOBKEY", nil}
 		}
 
 		authOk, err = checkJobAuth(parentJob.Auth, authHeader)
 		if err != nil {
-			return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+			return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 		}
 	} else {
 		for key, value := range req.Collections {
 			if !slugRegex.MatchString(key) {
-				return Submission{}, &SubmitError{http.StatusBadRequest, "invalid collection key", nil}
+				return nil, &SubmitError{http.StatusBadRequest, "invalid collection key", nil}
 			}
 			if !slugRegex.MatchString(value) {
-				return Submission{}, &SubmitError{http.StatusBadRequest, "invalid collection value", nil}
+				return nil, &SubmitError{http.StatusBadRequest, "invalid collection value", nil}
 			}
 		}
 
-		authOk, err = checkProjectAuth(project.Auth, authHeader)
+		authOk, err = checkProjectAuth(project.Id, authHeader)
 		if err != nil {
-			return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+			return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 		}
 	}
 	if !authOk {
-		return Submission{}, &SubmitError{http.StatusUnauthorized, "unauthorized", nil}
+		return nil, &SubmitError{http.StatusUnauthorized, "unauthorized", nil}
 	}
-	return Submission{req, project.Id}, nil
+	return []Submission{{req, project.Id}}, nil
 }
 
-func (SubmitEndpointGeneric) UpdateEntityStatus(project Project, entity EntityOrCollection) {
+func (SubmitEndpointGeneric) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
 	// no-op
+	return nil
 }
 
 // =============================================================================
-// /api/submit/darke
+// /api/v1/submit/darke
 // =============================================================================
 
 type SubmitEndpointDarke struct {
-	Repos map[string]GenericJobConfig `json:"repos"`
+	auraBaseUrl string
+
+	Repos map[string]SubmitEndpointDarkeJobConfig `json:"repos"`
+
+	// Which commit statuses to post, one of the Statuses* constants, defaults to StatusesAggregate
+	Statuses string `json:"statuses"`
+
+	// Status feedback is disabled if unset
+	ApiBaseUrl string `json:"apiBaseUrl"`
+}
+
+type SubmitEndpointDarkeJobConfig struct {
+	GenericJobConfig
+
+	Authorization string `json:"authorization"`
+	Secret        string `json:"secret"`
 }
 
 type SubmitRequestDarke struct {
@@ -453,40 +547,97 @@ type SubmitRequestDarke struct {
 	Commit  string
 }
 
-func NewSubmitEndpointDarke(cfgData json.RawMessage) (*SubmitEndpointDarke, error) {
+func NewSubmitEndpointDarke(auraBaseUrl string, cfgData json.RawMessage) (*SubmitEndpointDarke, error) {
 	var endpoint SubmitEndpointDarke
 	err := json.Unmarshal(cfgData, &endpoint)
 	if err != nil {
 		return nil, err
 	}
+	endpoint.ApiBaseUrl = strings.TrimSuffix(endpoint.ApiBaseUrl, "/")
+	if !validStatuses(endpoint.Statuses) {
+		return nil, fmt.Errorf("invalid statuses '%s'", endpoint.Statuses)
+	}
+	for name, repo := range endpoint.Repos {
+		if repo.Secret == "" {
+			return nil, fmt.Errorf("missing secret for repo '%s'", name)
+		}
+	}
+	endpoint.auraBaseUrl = auraBaseUrl
 	return &endpoint, nil
 }
 
-func (e *SubmitEndpointDarke) HandleRequest(r *http.Request) (Submission, *SubmitError) {
+func (e *SubmitEndpointDarke) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 	}
 	var req SubmitRequestDarke
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
+		return nil, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
 	}
 	repo, found := e.Repos[fmt.Sprintf("%s/%s", req.Owner, req.Repo)]
 	if !found {
-		return Submission{}, &SubmitError{http.StatusBadRequest, "repository not configured", err}
+		return nil, &SubmitError{http.StatusBadRequest, "repository not configured", err}
 	}
+	signatureHeader := r.Header.Get("X-Hub-Signature-256")
+	if signatureHeader == "" {
+		return nil, &SubmitError{http.StatusUnauthorized, "missing signature", err}
+	}
+	valid, err := validateWebhook(signatureHeader, repo.Secret, body)
+	if err != nil {
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+	}
+	if !valid {
+		return nil, &SubmitError{http.StatusUnauthorized, "invalid signature", err}
+	}
+
 	collections := map[string]string{}
 	collections["ref"] = req.RefName
-	return HandleGenericJobConfig(repo, "commit", req.Commit, collections)
+	sub, submitErr := HandleGenericJobConfig(repo.GenericJobConfig, "commit", req.Commit, collections)
+	if submitErr != nil {
+		return nil, submitErr
+	}
+	return []Submission{sub}, nil
 }
 
-func (e *SubmitEndpointDarke) UpdateEntityStatus(project Project, entity EntityOrCollection) {
-	// no-op
+func (e *SubmitEndpointDarke) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
+	if e.ApiBaseUrl == "" || entity.Key != "commit" {
+		return nil
+	}
+	for key, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			statuses, err := commitStatuses(e.auraBaseUrl, project, entity, e.Statuses)
+			if err != nil {
+				return err
+			}
+			url := fmt.Sprintf("%s/repos/%s/statuses/%s", e.ApiBaseUrl, key, entity.Val)
+			for _, status := range statuses {
+				err = postCommitStatus(url, repo.Authorization, status, false)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (e *SubmitEndpointDarke) reportsStatus(project Project) bool {
+	if e.ApiBaseUrl == "" {
+		return false
+	}
+	for _, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			return true
+		}
+	}
+	return false
 }
 
 // =============================================================================
-// /api/submit/gitea
+// /api/v1/submit/gitea
 // =============================================================================
 
 type SubmitEndpointGitea struct {
@@ -494,6 +645,9 @@ type SubmitEndpointGitea struct {
 
 	Repos map[string]SubmitEndpointGiteaJobConfig `json:"repos"`
 
+	// Which commit statuses to post, one of the Statuses* constants, defaults to StatusesAggregate
+	Statuses string `json:"statuses"`
+
 	ApiBaseUrl string `json:"apiBaseUrl"`
 }
 
@@ -502,15 +656,35 @@ type SubmitEndpointGiteaJobConfig struct {
 
 	Authorization string `json:"authorization"`
 	Secret        string `json:"secret"`
+
+	// Jobs to submit for pull requests instead of the job above, optional
+	PullRequests []GenericJobConfig `json:"pullRequests"`
+	// Replaces the env of the jobs for pull requests from forks, which must not get the secrets
+	ForkEnv string `json:"forkEnv"`
 }
 
 type SubmitRequestGitea struct {
+	// push
 	Ref   string `json:"ref"`
 	After string `json:"after"`
 
+	// pull_request
+	Action      string                        `json:"action"`
+	Number      int64                         `json:"number"`
+	PullRequest SubmitRequestGiteaPullRequest `json:"pull_request"`
+
 	Repository SubmitRequestGiteaRepository `json:"repository"`
 }
 
+type SubmitRequestGiteaPullRequest struct {
+	Head SubmitRequestGiteaPullRequestHead `json:"head"`
+}
+
+type SubmitRequestGiteaPullRequestHead struct {
+	Sha  string                       `json:"sha"`
+	Repo SubmitRequestGiteaRepository `json:"repo"`
+}
+
 type SubmitRequestGiteaRepository struct {
 	FullName string `json:"full_name"`
 }
@@ -521,91 +695,581 @@ func NewSubmitEndpointGitea(auraBaseUrl string, cfgData json.RawMessage) (*Submi
 	if err != nil {
 		return nil, err
 	}
+	if !validStatuses(endpoint.Statuses) {
+		return nil, fmt.Errorf("invalid statuses '%s'", endpoint.Statuses)
+	}
+	for name, repo := range endpoint.Repos {
+		if repo.Secret == "" {
+			return nil, fmt.Errorf("missing secret for repo '%s'", name)
+		}
+	}
 	endpoint.auraBaseUrl = auraBaseUrl
 	return &endpoint, nil
 }
 
-func (e *SubmitEndpointGitea) HandleRequest(r *http.Request) (Submission, *SubmitError) {
+func (e *SubmitEndpointGitea) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 	}
 	var req SubmitRequestGitea
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
+		return nil, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
 	}
 	repo, found := e.Repos[req.Repository.FullName]
 	if !found {
-		return Submission{}, &SubmitError{http.StatusBadRequest, "repository not configured", err}
+		return nil, &SubmitError{http.StatusBadRequest, "repository not configured", err}
 	}
 	signatureHeader := r.Header.Get("X-Hub-Signature-256")
 	if signatureHeader == "" {
-		return Submission{}, &SubmitError{http.StatusUnauthorized, "missing signature", err}
+		return nil, &SubmitError{http.StatusUnauthorized, "missing signature", err}
 	}
 	valid, err := validateWebhook(signatureHeader, repo.Secret, body)
 	if err != nil {
-		return Submission{}, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
 	}
 	if !valid {
-		return Submission{}, &SubmitError{http.StatusUnauthorized, "invalid signature", err}
+		return nil, &SubmitError{http.StatusUnauthorized, "invalid signature", err}
 	}
 
-	refParts := strings.Split(req.Ref, "/")
-	refName := refParts[len(refParts)-1]
 	collections := map[string]string{}
-	collections["ref"] = refName
-	return HandleGenericJobConfig(repo.GenericJobConfig, "commit", req.After, collections)
+	cfgs := []GenericJobConfig{repo.GenericJobConfig}
+	commit := ""
+	switch r.Header.Get("X-Gitea-Event") {
+	case "push":
+		// deleting a branch is a push without a commit
+		if strings.Trim(req.After, "0") == "" {
+			return nil, nil
+		}
+		refParts := strings.Split(req.Ref, "/")
+		refName := refParts[len(refParts)-1]
+		collections["ref"] = refName
+		commit = req.After
+	case "pull_request":
+		if req.Action != "opened" && req.Action != "synchronized" && req.Action != "reopened" {
+			return nil, nil
+		}
+		collections["pr"] = fmt.Sprintf("%d", req.Number)
+		commit = req.PullRequest.Head.Sha
+		if len(repo.PullRequests) > 0 {
+			cfgs = repo.PullRequests
+		}
+		if req.PullRequest.Head.Repo.FullName != req.Repository.FullName {
+			forkCfgs := []GenericJobConfig{}
+			for _, cfg := range cfgs {
+				cfg.Env = repo.ForkEnv
+				forkCfgs = append(forkCfgs, cfg)
+			}
+			cfgs = forkCfgs
+		}
+	default:
+		// every other event the webhook may be subscribed to
+		return nil, nil
+	}
+
+	subs := []Submission{}
+	for _, cfg := range cfgs {
+		sub, submitErr := HandleGenericJobConfig(cfg, "commit", commit, collections)
+		if submitErr != nil {
+			return nil, submitErr
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
 }
 
-func (e *SubmitEndpointGitea) UpdateEntityStatus(project Project, entity EntityOrCollection) {
-	if e.ApiBaseUrl != "" && entity.Key != "commit" {
-		return
+func (e *SubmitEndpointGitea) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
+	if e.ApiBaseUrl == "" || entity.Key != "commit" {
+		return nil
 	}
 	for key, repo := range e.Repos {
 		if repo.Project == project.Slug {
-			type reqObject struct {
-				Context     string `json:"context"`     // label of who is providing this status
-				Description string `json:"description"` // high-level summary
-				State       string `json:"state"`       // "pending", "success", "error", or "failure"
-				TargetUrl   string `json:"target_url"`  // full URL to build output
-			}
-			description, state, err := getEntityStatus(entity.Id)
+			statuses, err := commitStatuses(e.auraBaseUrl, project, entity, e.Statuses)
 			if err != nil {
-				log.Println(err)
-				return
+				return err
+			}
+			url := fmt.Sprintf("%s/repos/%s/statuses/%s", e.ApiBaseUrl, key, entity.Val)
+			for _, status := range statuses {
+				err = postCommitStatus(url, repo.Authorization, status, false)
+				if err != nil {
+					return err
+				}
 			}
-			targetUrl := fmt.Sprintf("%s/p/%s/%s/%s", e.auraBaseUrl, project.Slug, entity.Key, entity.Val)
-			reqObj := reqObject{Context: "Aura", Description: description, State: state, TargetUrl: targetUrl}
+			return nil
+		}
+	}
+	return nil
+}
 
-			reqData, err := json.Marshal(reqObj)
-			if err != nil {
-				log.Println(err)
-				return
+func (e *SubmitEndpointGitea) reportsStatus(project Project) bool {
+	if e.ApiBaseUrl == "" {
+		return false
+	}
+	for _, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+// commitStatus is the request body of the commit status APIs of Darke, Gitea, GitHub and GitLab
+type commitStatus struct {
+	Context     string `json:"context"`     // label of who is providing this status
+	Description string `json:"description"` // high-level summary
+	State       string `json:"state"`       // "pending", "success", "error", or "failure"
+	TargetUrl   string `json:"target_url"`  // full URL to build output
+}
+
+// Values of the statuses option of the integrations posting commit statuses
+const (
+	StatusesAggregate = "aggregate" // one status summarizing all jobs
+	StatusesJobs      = "jobs"      // one status per job name, so that single jobs can be required
+	StatusesBoth      = "both"
+)
+
+func validStatuses(statuses string) bool {
+	return statuses == "" || statuses == StatusesAggregate || statuses == StatusesJobs || statuses == StatusesBoth
+}
+
+// commitStatuses returns the statuses to post for the entity.
+// The aggregate status has the context Aura, the status of the most recent job with each name has the context Aura/<name>.
+func commitStatuses(auraBaseUrl string, project Project, entity EntityOrCollection, statuses string) ([]commitStatus, error) {
+	results := []commitStatus{}
+	if statuses != StatusesJobs {
+		description, state, err := getEntityStatus(entity.Id)
+		if err != nil {
+			return nil, err
+		}
+		targetUrl := fmt.Sprintf("%s/p/%s/%s/%s", auraBaseUrl, project.Slug, entity.Key, entity.Val)
+		results = append(results, commitStatus{Context: "Aura", Description: description, State: state, TargetUrl: targetUrl})
+	}
+	if statuses == StatusesJobs || statuses == StatusesBoth {
+		jobs, err := FindJobs(entity.Id)
+		if err != nil {
+			return nil, err
+		}
+		latest := map[string]Job{}
+		for _, job := range jobs {
+			if previous, found := latest[job.Name]; !found || job.Id > previous.Id {
+				latest[job.Name] = job
 			}
-			url := fmt.Sprintf("%s/repos/%s/statuses/%s", e.ApiBaseUrl, key, entity.Val)
-			req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(reqData))
+		}
+		names := make([]string, 0, len(latest))
+		for name := range latest {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			job := latest[name]
+			state, description := jobCommitStatus(job)
+			targetUrl := fmt.Sprintf("%s/j/%d", auraBaseUrl, job.Id)
+			results = append(results, commitStatus{Context: "Aura/" + job.Name, Description: description, State: state, TargetUrl: targetUrl})
+		}
+	}
+	return results, nil
+}
+
+// jobCommitStatus returns the state and description of the commit status of a single job
+func jobCommitStatus(job Job) (string, string) {
+	switch job.Status {
+	case StatusStarted:
+		return "pending", "running"
+	case StatusSucceeded:
+		return "success", "succeeded"
+	case StatusFailed:
+		if job.Message != "" {
+			// the APIs limit the description to 140 characters
+			description := []rune(fmt.Sprintf("failed with exit code %d: %s", job.ExitCode, job.Message))
+			if len(description) > 140 {
+				description = append(description[:139], '…')
+			}
+			return "failure", string(description)
+		}
+		return "failure", fmt.Sprintf("failed with exit code %d", job.ExitCode)
+	case StatusCancelled:
+		return "error", "cancelled"
+	default:
+		return "pending", "queued"
+	}
+}
+
+// statusClient is used for all commit status updates so that an unresponsive forge cannot hold up the outbox
+var statusClient = &http.Client{Timeout: 10 * time.Second}
+
+// postCommitStatus posts the status to the commit status API at url
+func postCommitStatus(url string, authorization string, status commitStatus, github bool) error {
+	reqData, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(reqData))
+	if err != nil {
+		return err
+	}
+	if github {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+	resp, err := statusClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		// the start of the body usually tells what is wrong
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		if len(body) > 0 {
+			return fmt.Errorf("got status %s: %s", resp.Status, body)
+		}
+		return errors.New("got status " + resp.Status)
+	}
+	return nil
+}
+
+// =============================================================================
+// /api/v1/submit/github
+// =============================================================================
+
+const githubApiBaseUrl = "https://api.github.com"
+
+type SubmitEndpointGithub struct {
+	auraBaseUrl string
+
+	Repos map[string]SubmitEndpointGithubJobConfig `json:"repos"`
+
+	// Which commit statuses to post, one of the Statuses* constants, defaults to StatusesAggregate
+	Statuses string `json:"statuses"`
+
+	// Defaults to githubApiBaseUrl, set for GitHub Enterprise Server
+	ApiBaseUrl string `json:"apiBaseUrl"`
+}
+
+type SubmitEndpointGithubJobConfig struct {
+	GenericJobConfig
+
+	Authorization string `json:"authorization"`
+	Secret        string `json:"secret"`
+}
+
+type SubmitRequestGithub struct {
+	// push
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+
+	// pull_request
+	Action      string                         `json:"action"`
+	Number      int64                          `json:"number"`
+	PullRequest SubmitRequestGithubPullRequest `json:"pull_request"`
+
+	Repository SubmitRequestGithubRepository `json:"repository"`
+}
+
+type SubmitRequestGithubPullRequest struct {
+	Head SubmitRequestGithubPullRequestHead `json:"head"`
+}
+
+type SubmitRequestGithubPullRequestHead struct {
+	Sha  string                        `json:"sha"`
+	Repo SubmitRequestGithubRepository `json:"repo"`
+}
+
+type SubmitRequestGithubRepository struct {
+	FullName string `json:"full_name"`
+}
+
+func NewSubmitEndpointGithub(auraBaseUrl string, cfgData json.RawMessage) (*SubmitEndpointGithub, error) {
+	var endpoint SubmitEndpointGithub
+	err := json.Unmarshal(cfgData, &endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.ApiBaseUrl == "" {
+		endpoint.ApiBaseUrl = githubApiBaseUrl
+	}
+	endpoint.ApiBaseUrl = strings.TrimSuffix(endpoint.ApiBaseUrl, "/")
+	if !validStatuses(endpoint.Statuses) {
+		return nil, fmt.Errorf("invalid statuses '%s'", endpoint.Statuses)
+	}
+	for name, repo := range endpoint.Repos {
+		if repo.Secret == "" {
+			return nil, fmt.Errorf("missing secret for repo '%s'", name)
+		}
+	}
+	endpoint.auraBaseUrl = auraBaseUrl
+	return &endpoint, nil
+}
+
+func (e *SubmitEndpointGithub) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+	}
+	var req SubmitRequestGithub
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
+	}
+	repo, found := e.Repos[req.Repository.FullName]
+	if !found {
+		return nil, &SubmitError{http.StatusBadRequest, "repository not configured", err}
+	}
+	signatureHeader := r.Header.Get("X-Hub-Signature-256")
+	if signatureHeader == "" {
+		return nil, &SubmitError{http.StatusUnauthorized, "missing signature", err}
+	}
+	valid, err := validateWebhook(signatureHeader, repo.Secret, body)
+	if err != nil {
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+	}
+	if !valid {
+		return nil, &SubmitError{http.StatusUnauthorized, "invalid signature", err}
+	}
+
+	collections := map[string]string{}
+	cfg := repo.GenericJobConfig
+	commit := ""
+	switch r.Header.Get("X-GitHub-Event") {
+	case "push":
+		// new tags are pushed like branches, deleting a branch or tag is a push without a commit
+		if req.Deleted || strings.Trim(req.After, "0") == "" {
+			return nil, nil
+		}
+		refParts := strings.Split(req.Ref, "/")
+		refName := refParts[len(refParts)-1]
+		if strings.HasPrefix(req.Ref, "refs/tags/") {
+			collections["tag"] = refName
+		} else {
+			collections["ref"] = refName
+		}
+		commit = req.After
+	case "pull_request":
+		if req.Action != "opened" && req.Action != "synchronize" && req.Action != "reopened" {
+			return nil, nil
+		}
+		collections["pr"] = fmt.Sprintf("%d", req.Number)
+		commit = req.PullRequest.Head.Sha
+		// the env usually contains secrets that must not be handed to code from forks
+		if req.PullRequest.Head.Repo.FullName != req.Repository.FullName {
+			cfg.Env = ""
+		}
+	default:
+		// ping and every other event the webhook may be subscribed to
+		return nil, nil
+	}
+
+	sub, submitErr := HandleGenericJobConfig(cfg, "commit", commit, collections)
+	if submitErr != nil {
+		return nil, submitErr
+	}
+	return []Submission{sub}, nil
+}
+
+func (e *SubmitEndpointGithub) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
+	if entity.Key != "commit" {
+		return nil
+	}
+	for key, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			statuses, err := commitStatuses(e.auraBaseUrl, project, entity, e.Statuses)
 			if err != nil {
-				log.Println(err)
-				return
+				return err
 			}
-			if e.ApiBaseUrl == "https://api.github.com" {
-				req.Header.Set("Accept", "application/vnd.github+json")
-				req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+			url := fmt.Sprintf("%s/repos/%s/statuses/%s", e.ApiBaseUrl, key, entity.Val)
+			for _, status := range statuses {
+				err = postCommitStatus(url, repo.Authorization, status, true)
+				if err != nil {
+					return err
+				}
 			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", repo.Authorization)
-			resp, err := http.DefaultClient.Do(req)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (e *SubmitEndpointGithub) reportsStatus(project Project) bool {
+	for _, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// /api/v1/submit/gitlab
+// =============================================================================
+
+type SubmitEndpointGitlab struct {
+	auraBaseUrl string
+
+	Repos map[string]SubmitEndpointGitlabJobConfig `json:"repos"`
+
+	// Which commit statuses to post, one of the Statuses* constants, defaults to StatusesAggregate
+	Statuses string `json:"statuses"`
+
+	ApiBaseUrl string `json:"apiBaseUrl"`
+}
+
+type SubmitEndpointGitlabJobConfig struct {
+	GenericJobConfig
+
+	Authorization string `json:"authorization"`
+	Secret        string `json:"secret"`
+}
+
+type SubmitRequestGitlab struct {
+	// push and tag_push
+	Ref         string `json:"ref"`
+	CheckoutSha string `json:"checkout_sha"`
+
+	// merge_request
+	ObjectAttributes SubmitRequestGitlabMergeRequest `json:"object_attributes"`
+
+	Project SubmitRequestGitlabProject `json:"project"`
+}
+
+type SubmitRequestGitlabMergeRequest struct {
+	Iid             int64                         `json:"iid"`
+	Action          string                        `json:"action"`
+	OldRev          string                        `json:"oldrev"`
+	SourceProjectId int64                         `json:"source_project_id"`
+	TargetProjectId int64                         `json:"target_project_id"`
+	LastCommit      SubmitRequestGitlabLastCommit `json:"last_commit"`
+}
+
+type SubmitRequestGitlabLastCommit struct {
+	Id string `json:"id"`
+}
+
+type SubmitRequestGitlabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+func NewSubmitEndpointGitlab(auraBaseUrl string, cfgData json.RawMessage) (*SubmitEndpointGitlab, error) {
+	var endpoint SubmitEndpointGitlab
+	err := json.Unmarshal(cfgData, &endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.ApiBaseUrl == "" {
+		return nil, errors.New("missing apiBaseUrl")
+	}
+	endpoint.ApiBaseUrl = strings.TrimSuffix(endpoint.ApiBaseUrl, "/")
+	if !validStatuses(endpoint.Statuses) {
+		return nil, fmt.Errorf("invalid statuses '%s'", endpoint.Statuses)
+	}
+	endpoint.auraBaseUrl = auraBaseUrl
+	return &endpoint, nil
+}
+
+func (e *SubmitEndpointGitlab) HandleRequest(r *http.Request) ([]Submission, *SubmitError) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &SubmitError{http.StatusInternalServerError, "internal server error", err}
+	}
+	var req SubmitRequestGitlab
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, &SubmitError{http.StatusBadRequest, "unable to unmarshal json object", err}
+	}
+	repo, found := e.Repos[req.Project.PathWithNamespace]
+	if !found {
+		return nil, &SubmitError{http.StatusBadRequest, "repository not configured", err}
+	}
+	// GitLab does not sign the payload but sends the configured secret token as is
+	tokenHeader := r.Header.Get("X-Gitlab-Token")
+	if tokenHeader == "" {
+		return nil, &SubmitError{http.StatusUnauthorized, "missing token", err}
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenHeader), []byte(repo.Secret)) != 1 {
+		return nil, &SubmitError{http.StatusUnauthorized, "invalid token", err}
+	}
+
+	collections := map[string]string{}
+	cfg := repo.GenericJobConfig
+	commit := ""
+	switch r.Header.Get("X-Gitlab-Event") {
+	case "Push Hook", "Tag Push Hook":
+		// deleting a branch or tag is a push without a commit to check out
+		if req.CheckoutSha == "" || strings.Trim(req.CheckoutSha, "0") == "" {
+			return nil, nil
+		}
+		refParts := strings.Split(req.Ref, "/")
+		refName := refParts[len(refParts)-1]
+		if strings.HasPrefix(req.Ref, "refs/tags/") {
+			collections["tag"] = refName
+		} else {
+			collections["ref"] = refName
+		}
+		commit = req.CheckoutSha
+	case "Merge Request Hook":
+		mr := req.ObjectAttributes
+		// updates without oldrev only changed the title, labels, assignees and the like
+		if mr.Action != "open" && mr.Action != "reopen" && (mr.Action != "update" || mr.OldRev == "") {
+			return nil, nil
+		}
+		collections["mr"] = fmt.Sprintf("%d", mr.Iid)
+		commit = mr.LastCommit.Id
+		// the env usually contains secrets that must not be handed to code from forks
+		if mr.SourceProjectId != mr.TargetProjectId {
+			cfg.Env = ""
+		}
+	default:
+		// every other event the webhook may be subscribed to
+		return nil, nil
+	}
+
+	sub, submitErr := HandleGenericJobConfig(cfg, "commit", commit, collections)
+	if submitErr != nil {
+		return nil, submitErr
+	}
+	return []Submission{sub}, nil
+}
+
+func (e *SubmitEndpointGitlab) UpdateEntityStatus(project Project, entity EntityOrCollection) error {
+	if entity.Key != "commit" {
+		return nil
+	}
+	for key, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			statuses, err := commitStatuses(e.auraBaseUrl, project, entity, e.Statuses)
 			if err != nil {
-				log.Println(err)
-				return
+				return err
 			}
-			if resp.StatusCode != http.StatusCreated {
-				log.Println("got status " + resp.Status)
+			statusUrl := fmt.Sprintf("%s/projects/%s/statuses/%s", e.ApiBaseUrl, url.PathEscape(key), entity.Val)
+			for _, status := range statuses {
+				// GitLab calls the states failed and canceled instead of failure and error
+				switch status.State {
+				case "failure":
+					status.State = "failed"
+				case "error":
+					status.State = "canceled"
+				}
+				err = postCommitStatus(statusUrl, repo.Authorization, status, false)
+				// GitLab refuses to set the state a status already has
+				if err != nil && !strings.Contains(err.Error(), "Cannot transition status") {
+					return err
+				}
 			}
-			return
+			return nil
+		}
+	}
+	return nil
+}
+
+func (e *SubmitEndpointGitlab) reportsStatus(project Project) bool {
+	for _, repo := range e.Repos {
+		if repo.Project == project.Slug {
+			return true
 		}
 	}
+	return false
 }
 
 func validateWebhook(signature string, secret string, payload []byte) (bool, error) {
@@ -617,8 +1281,6 @@ func validateWebhook(signature string, secret string, payload []byte) (bool, err
 		return true, nil
 	}
 
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write(payload)
-	sig := hex.EncodeToString(h.Sum(nil))
+	sig := signWebhook(secret, payload)
 	return subtle.ConstantTimeCompare([]byte(signature), []byte(sig)) == 1, nil
 }
@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestValidateWebhook(t *testing.T) {
 	ok, err := validateWebhook(
@@ -12,3 +22,553 @@ func TestValidateWebhook(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// setupTestDatabase opens a new database with the project colors for the duration of the test
+func setupTestDatabase(t testing.TB) Project {
+	var err error
+	db, err = sql.Open("sqlite", filepath.Join(t.TempDir(), "aura.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = InitializeDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectId, err := CreateProject("Colors", "colors", []byte{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := LoadProject(projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+// newSignedRequest returns a webhook request signed with secret like Gitea and GitHub do
+func newSignedRequest(t *testing.T, secret string, payload any, headers map[string]string) *http.Request {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/submit", bytes.NewReader(body))
+	r.Header.Set("X-Hub-Signature-256", "sha256="+signWebhook(secret, body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+// fakeStatusServer records the commit statuses posted to it
+func fakeStatusServer(t *testing.T) (*httptest.Server, chan *http.Request, chan commitStatus) {
+	requests := make(chan *http.Request, 10)
+	statuses := make(chan commitStatus, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status commitStatus
+		err := json.NewDecoder(r.Body).Decode(&status)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- r
+		statuses <- status
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, statuses
+}
+
+// checkSubmission checks that the request submits a single job for the commit sha with the collections and env.
+// A code other than 0 is the expected error and no collections mean that no job is expected.
+func checkSubmission(t *testing.T, endpoint SubmitEndpoint, r *http.Request, name string, projectId int64, sha string, code int, collections map[string]string, env string) {
+	t.Helper()
+	subs, submitErr := endpoint.HandleRequest(r)
+	if code != 0 {
+		if submitErr == nil || submitErr.code != code {
+			t.Errorf("%s: expected error %d, got %v", name, code, submitErr)
+		}
+		return
+	}
+	if submitErr != nil {
+		t.Errorf("%s: unexpected error %d %s", name, submitErr.code, submitErr.msg)
+		return
+	}
+	if collections == nil {
+		if len(subs) != 0 {
+			t.Errorf("%s: expected no submissions, got %v", name, subs)
+		}
+		return
+	}
+	if len(subs) != 1 {
+		t.Errorf("%s: expected one submission, got %v", name, subs)
+		return
+	}
+	sub := subs[0]
+	if sub.EntityKey != "commit" || sub.EntityVal != sha || sub.ProjectId != projectId || sub.Env != env {
+		t.Errorf("%s: unexpected submission %+v", name, sub)
+	}
+	for k, v := range collections {
+		if sub.Collections[k] != v || len(sub.Collections) != len(collections) {
+			t.Errorf("%s: expected collections %v, got %v", name, collections, sub.Collections)
+		}
+	}
+}
+
+func TestSubmitEndpointGithub(t *testing.T) {
+	project := setupTestDatabase(t)
+	server, requests, statuses := fakeStatusServer(t)
+	endpoint, err := NewSubmitEndpointGithub("http://aura.example", json.RawMessage(`{
+		"apiBaseUrl": "`+server.URL+`",
+		"repos": {"user/repo": {"project": "colors", "name": "build", "cmd": "make", "env": "TOKEN=secret", "tag": "native,linux", "secret": "s3cret", "authorization": "Bearer abc"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := map[string]any{"full_name": "user/repo"}
+	sha := "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		name        string
+		event       string
+		secret      string
+		payload     map[string]any
+		code        int
+		collections map[string]string
+		env         string
+	}{
+		{"ping", "ping", "s3cret", map[string]any{"zen": "Keep it logically awesome.", "repository": repository}, 0, nil, ""},
+		{"bad signature", "push", "wrong", map[string]any{"ref": "refs/heads/main", "after": sha, "repository": repository}, http.StatusUnauthorized, nil, ""},
+		{"push", "push", "s3cret", map[string]any{"ref": "refs/heads/main", "after": sha, "repository": repository}, 0, map[string]string{"ref": "main"}, "TOKEN=secret"},
+		{"tag", "push", "s3cret", map[string]any{"ref": "refs/tags/v1.0", "after": sha, "repository": repository}, 0, map[string]string{"tag": "v1.0"}, "TOKEN=secret"},
+		{"branch deleted", "push", "s3cret", map[string]any{"ref": "refs/heads/old", "after": "0000000000000000000000000000000000000000", "deleted": true, "repository": repository}, 0, nil, ""},
+		{"pull request", "pull_request", "s3cret", map[string]any{"action": "opened", "number": 5, "pull_request": map[string]any{"head": map[string]any{"sha": sha, "repo": repository}}, "repository": repository}, 0, map[string]string{"pr": "5"}, "TOKEN=secret"},
+		{"pull request from fork", "pull_request", "s3cret", map[string]any{"action": "synchronize", "number": 6, "pull_request": map[string]any{"head": map[string]any{"sha": sha, "repo": map[string]any{"full_name": "fork/repo"}}}, "repository": repository}, 0, map[string]string{"pr": "6"}, ""},
+		{"pull request closed", "pull_request", "s3cret", map[string]any{"action": "closed", "number": 5, "pull_request": map[string]any{"head": map[string]any{"sha": sha, "repo": repository}}, "repository": repository}, 0, nil, ""},
+	}
+	for _, test := range tests {
+		r := newSignedRequest(t, test.secret, test.payload, map[string]string{"X-GitHub-Event": test.event})
+		checkSubmission(t, endpoint, r, test.name, project.Id, sha, test.code, test.collections, test.env)
+	}
+
+	err = CreateEntity(project.Id, "commit", sha, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := FindEntity(project.Id, "commit", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateJob(entity.Id, "build", time.Now(), time.Now(), "make", "", "native,linux", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = endpoint.UpdateEntityStatus(project, entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	status := <-statuses
+	if r.URL.Path != "/repos/user/repo/statuses/"+sha || r.Header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("unexpected status request %s %v", r.URL.Path, r.Header)
+	}
+	if status.State != "pending" || status.Context != "Aura" || status.TargetUrl != "http://aura.example/p/colors/commit/"+sha {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestSubmitEndpointEmptySecret(t *testing.T) {
+	cfg := json.RawMessage(`{
+		"apiBaseUrl": "http://git.example/api",
+		"repos": {"user/repo": {"project": "colors", "name": "build", "cmd": "make", "tag": "native,linux", "secret": ""}}
+	}`)
+	tests := []struct {
+		name   string
+		create func(auraBaseUrl string, cfgData json.RawMessage) error
+	}{
+		{"github", func(auraBaseUrl string, cfgData json.RawMessage) error {
+			_, err := NewSubmitEndpointGithub(auraBaseUrl, cfgData)
+			return err
+		}},
+		{"darke", func(auraBaseUrl string, cfgData json.RawMessage) error {
+			_, err := NewSubmitEndpointDarke(auraBaseUrl, cfgData)
+			return err
+		}},
+		{"gitea", func(auraBaseUrl string, cfgData json.RawMessage) error {
+			_, err := NewSubmitEndpointGitea(auraBaseUrl, cfgData)
+			return err
+		}},
+	}
+	for _, test := range tests {
+		err := test.create("http://aura.example", cfg)
+		if err == nil {
+			t.Errorf("%s: expected a repo without secret to be refused", test.name)
+		}
+	}
+}
+
+func TestSubmitEndpointGitlab(t *testing.T) {
+	project := setupTestDatabase(t)
+	server, requests, statuses := fakeStatusServer(t)
+	endpoint, err := NewSubmitEndpointGitlab("http://aura.example", json.RawMessage(`{
+		"apiBaseUrl": "`+server.URL+`",
+		"repos": {"group/repo": {"project": "colors", "name": "build", "cmd": "make", "env": "TOKEN=secret", "tag": "native,linux", "secret": "s3cret", "authorization": "Bearer abc"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := map[string]any{"path_with_namespace": "group/repo"}
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	mergeRequest := func(action string, oldrev string, sourceProjectId int64) map[string]any {
+		return map[string]any{
+			"object_kind": "merge_request",
+			"object_attributes": map[string]any{
+				"iid": 7, "action": action, "oldrev": oldrev, "source_project_id": sourceProjectId, "target_project_id": 1,
+				"last_commit": map[string]any{"id": sha},
+			},
+			"project": repository,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		event       string
+		token       string
+		payload     map[string]any
+		code        int
+		collections map[string]string
+		env         string
+	}{
+		{"missing token", "Push Hook", "", map[string]any{"ref": "refs/heads/main", "checkout_sha": sha, "project": repository}, http.StatusUnauthorized, nil, ""},
+		{"bad token", "Push Hook", "wrong", map[string]any{"ref": "refs/heads/main", "checkout_sha": sha, "project": repository}, http.StatusUnauthorized, nil, ""},
+		{"push", "Push Hook", "s3cret", map[string]any{"ref": "refs/heads/main", "checkout_sha": sha, "project": repository}, 0, map[string]string{"ref": "main"}, "TOKEN=secret"},
+		{"branch deleted", "Push Hook", "s3cret", map[string]any{"ref": "refs/heads/old", "checkout_sha": nil, "project": repository}, 0, nil, ""},
+		{"tag", "Tag Push Hook", "s3cret", map[string]any{"ref": "refs/tags/v1.0", "checkout_sha": sha, "project": repository}, 0, map[string]string{"tag": "v1.0"}, "TOKEN=secret"},
+		{"merge request", "Merge Request Hook", "s3cret", mergeRequest("open", "", 1), 0, map[string]string{"mr": "7"}, "TOKEN=secret"},
+		{"merge request with new commits", "Merge Request Hook", "s3cret", mergeRequest("update", "fedcba", 1), 0, map[string]string{"mr": "7"}, "TOKEN=secret"},
+		{"merge request edited", "Merge Request Hook", "s3cret", mergeRequest("update", "", 1), 0, nil, ""},
+		{"merge request from fork", "Merge Request Hook", "s3cret", mergeRequest("reopen", "", 2), 0, map[string]string{"mr": "7"}, ""},
+		{"merge request merged", "Merge Request Hook", "s3cret", mergeRequest("merge", "", 1), 0, nil, ""},
+		{"other event", "Note Hook", "s3cret", map[string]any{"object_kind": "note", "project": repository}, 0, nil, ""},
+	}
+	for _, test := range tests {
+		body, err := json.Marshal(test.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/submit/gitlab", bytes.NewReader(body))
+		r.Header.Set("X-Gitlab-Event", test.event)
+		if test.token != "" {
+			r.Header.Set("X-Gitlab-Token", test.token)
+		}
+		checkSubmission(t, endpoint, r, test.name, project.Id, sha, test.code, test.collections, test.env)
+	}
+
+	err = CreateEntity(project.Id, "commit", sha, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := FindEntity(project.Id, "commit", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobId, err := CreateJob(entity.Id, "build", time.Now(), time.Now(), "make", "", "native,linux", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MarkJobDone(jobId, StatusFailed, 1, "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = endpoint.UpdateEntityStatus(project, entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	status := <-statuses
+	if r.URL.EscapedPath() != "/projects/group%2Frepo/statuses/"+sha || r.Header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("unexpected status request %s %v", r.URL.EscapedPath(), r.Header)
+	}
+	if status.State != "failed" || status.Context != "Aura" || status.TargetUrl != "http://aura.example/p/colors/commit/"+sha {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestSubmitEndpointGitea(t *testing.T) {
+	project := setupTestDatabase(t)
+	server, requests, statuses := fakeStatusServer(t)
+	endpoint, err := NewSubmitEndpointGitea("http://aura.example", json.RawMessage(`{
+		"apiBaseUrl": "`+server.URL+`/api/v1",
+		"repos": {"user/repo": {
+			"project": "colors", "name": "build", "cmd": "make", "env": "TOKEN=secret", "tag": "native,linux", "secret": "s3cret", "authorization": "token abc",
+			"pullRequests": [
+				{"project": "colors", "name": "build", "cmd": "make", "env": "TOKEN=secret", "tag": "native,linux"},
+				{"project": "colors", "name": "lint", "cmd": "make lint", "tag": "native,linux"}
+			],
+			"forkEnv": "CI=true"
+		}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := map[string]any{"full_name": "user/repo"}
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	pullRequest := func(action string, head string) map[string]any {
+		return map[string]any{"action": action, "number": 3, "pull_request": map[string]any{"head": map[string]any{"sha": sha, "repo": map[string]any{"full_name": head}}}, "repository": repository}
+	}
+
+	tests := []struct {
+		name        string
+		event       string
+		payload     map[string]any
+		jobs        []string
+		collections map[string]string
+		envs        []string
+	}{
+		{"push", "push", map[string]any{"ref": "refs/heads/main", "after": sha, "repository": repository}, []string{"build"}, map[string]string{"ref": "main"}, []string{"TOKEN=secret"}},
+		{"branch deleted", "push", map[string]any{"ref": "refs/heads/old", "after": "0000000000000000000000000000000000000000", "repository": repository}, nil, nil, nil},
+		{"pull request", "pull_request", pullRequest("opened", "user/repo"), []string{"build", "lint"}, map[string]string{"pr": "3"}, []string{"TOKEN=secret", ""}},
+		{"pull request synchronized", "pull_request", pullRequest("synchronized", "user/repo"), []string{"build", "lint"}, map[string]string{"pr": "3"}, []string{"TOKEN=secret", ""}},
+		{"pull request from fork", "pull_request", pullRequest("reopened", "fork/repo"), []string{"build", "lint"}, map[string]string{"pr": "3"}, []string{"CI=true", "CI=true"}},
+		{"pull request closed", "pull_request", pullRequest("closed", "user/repo"), nil, nil, nil},
+		{"other event", "issues", map[string]any{"action": "opened", "repository": repository}, nil, nil, nil},
+	}
+	for _, test := range tests {
+		r := newSignedRequest(t, "s3cret", test.payload, map[string]string{"X-Gitea-Event": test.event})
+		subs, submitErr := endpoint.HandleRequest(r)
+		if submitErr != nil {
+			t.Errorf("%s: unexpected error %d %s", test.name, submitErr.code, submitErr.msg)
+			continue
+		}
+		if len(subs) != len(test.jobs) {
+			t.Errorf("%s: expected %d submissions, got %v", test.name, len(test.jobs), subs)
+			continue
+		}
+		for i, sub := range subs {
+			if sub.Name != test.jobs[i] || sub.EntityKey != "commit" || sub.EntityVal != sha || sub.ProjectId != project.Id || sub.Env != test.envs[i] {
+				t.Errorf("%s: unexpected submission %+v", test.name, sub)
+			}
+			for k, v := range test.collections {
+				if sub.Collections[k] != v || len(sub.Collections) != len(test.collections) {
+					t.Errorf("%s: expected collections %v, got %v", test.name, test.collections, sub.Collections)
+				}
+			}
+		}
+	}
+
+	r := newSignedRequest(t, "wrong", map[string]any{"ref": "refs/heads/main", "after": sha, "repository": repository}, map[string]string{"X-Gitea-Event": "push"})
+	_, submitErr := endpoint.HandleRequest(r)
+	if submitErr == nil || submitErr.code != http.StatusUnauthorized {
+		t.Errorf("expected invalid signature to be rejected, got %v", submitErr)
+	}
+
+	err = CreateEntity(project.Id, "commit", sha, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := FindEntity(project.Id, "commit", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobId, err := CreateJob(entity.Id, "build", time.Now(), time.Now(), "make", "", "native,linux", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MarkJobDone(jobId, StatusFailed, 1, "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = endpoint.UpdateEntityStatus(project, EntityOrCollection{Id: entity.Id, ProjectId: project.Id, Key: "ref", Val: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-requests:
+		t.Errorf("expected no status for a collection, got %s", req.URL.Path)
+	default:
+	}
+	withoutApi, err := NewSubmitEndpointGitea("http://aura.example", json.RawMessage(`{
+		"repos": {"user/repo": {"project": "colors", "name": "build", "cmd": "make", "tag": "native,linux", "secret": "s3cret"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = withoutApi.UpdateEntityStatus(project, entity)
+	if err != nil {
+		t.Errorf("expected no status without apiBaseUrl, got %s", err)
+	}
+	err = endpoint.UpdateEntityStatus(project, entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	status := <-statuses
+	if req.URL.Path != "/api/v1/repos/user/repo/statuses/"+sha || req.Header.Get("Authorization") != "token abc" {
+		t.Errorf("unexpected status request %s %v", req.URL.Path, req.Header)
+	}
+	if status.State != "failure" || status.TargetUrl != "http://aura.example/p/colors/commit/"+sha {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestSubmitEndpointDarke(t *testing.T) {
+	project := setupTestDatabase(t)
+	server, requests, statuses := fakeStatusServer(t)
+	endpoint, err := NewSubmitEndpointDarke("http://aura.example", json.RawMessage(`{
+		"apiBaseUrl": "`+server.URL+`/api",
+		"repos": {"user/repo": {"project": "colors", "name": "build", "cmd": "make", "tag": "native,linux", "secret": "s3cret", "authorization": "token abc"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	payload := map[string]any{"Owner": "user", "Repo": "repo", "RefName": "main", "Commit": sha}
+
+	r := newSignedRequest(t, "wrong", payload, nil)
+	_, submitErr := endpoint.HandleRequest(r)
+	if submitErr == nil || submitErr.code != http.StatusUnauthorized {
+		t.Errorf("expected invalid signature to be rejected, got %v", submitErr)
+	}
+	r = newSignedRequest(t, "s3cret", payload, nil)
+	r.Header.Del("X-Hub-Signature-256")
+	_, submitErr = endpoint.HandleRequest(r)
+	if submitErr == nil || submitErr.code != http.StatusUnauthorized {
+		t.Errorf("expected missing signature to be rejected, got %v", submitErr)
+	}
+	r = newSignedRequest(t, "s3cret", payload, nil)
+	subs, submitErr := endpoint.HandleRequest(r)
+	if submitErr != nil {
+		t.Fatalf("unexpected error %d %s", submitErr.code, submitErr.msg)
+	}
+	if len(subs) != 1 || subs[0].EntityVal != sha || subs[0].Collections["ref"] != "main" || subs[0].ProjectId != project.Id {
+		t.Errorf("unexpected submissions %+v", subs)
+	}
+
+	err = CreateEntity(project.Id, "commit", sha, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := FindEntity(project.Id, "commit", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobId, err := CreateJob(entity.Id, "build", time.Now(), time.Now(), "make", "", "native,linux", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MarkJobDone(jobId, StatusSucceeded, 0, "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = endpoint.UpdateEntityStatus(project, entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	status := <-statuses
+	if req.URL.Path != "/api/repos/user/repo/statuses/"+sha || req.Header.Get("Authorization") != "token abc" {
+		t.Errorf("unexpected status request %s %v", req.URL.Path, req.Header)
+	}
+	if status.State != "success" || status.Description != "1 succeeded" || status.TargetUrl != "http://aura.example/p/colors/commit/"+sha {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestNewSubmitEndpoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   string
+		typ   string
+		valid bool
+	}{
+		{"gitea", `{"apiBaseUrl": "http://gitea.example/api/v1"}`, "gitea", true},
+		{"gitea-internal", `{"type": "gitea", "apiBaseUrl": "http://gitea.internal/api/v1"}`, "gitea", true},
+		{"company", `{"type": "gitlab", "apiBaseUrl": "http://gitlab.example/api/v4"}`, "gitlab", true},
+		{"gitlab", `{}`, "", false}, // GitLab has no default API
+		{"unknown", `{}`, "", false},
+		{"company", `{"type": "unknown"}`, "", false},
+		{"with/slash", `{"type": "gitea"}`, "", false},
+	}
+	for _, test := range tests {
+		endpoint, typ, err := newSubmitEndpoint(test.name, json.RawMessage(test.cfg))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil || endpoint == nil || typ != test.typ {
+			t.Errorf("%s: expected type %s, got %s %v", test.name, test.typ, typ, err)
+		}
+	}
+}
+
+func TestCommitStatuses(t *testing.T) {
+	project := setupTestDatabase(t)
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	err := CreateEntity(project.Id, "commit", sha, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := FindEntity(project.Id, "commit", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobIds := map[string]int64{}
+	for _, job := range []struct {
+		name     string
+		status   int
+		exitCode int64
+	}{
+		{"test", StatusFailed, 2},
+		{"test", StatusSucceeded, 0},
+		{"lint", StatusFailed, 1},
+		{"deploy", StatusCancelled, 0},
+		{"docs", StatusSubmitted, 0},
+	} {
+		jobId, err := CreateJob(entity.Id, job.name, time.Now(), time.Now(), "make", "", "native,linux", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.status != StatusSubmitted {
+			err = MarkJobDone(jobId, job.status, job.exitCode, "", time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		jobIds[job.name] = jobId
+	}
+
+	statuses, err := commitStatuses("http://aura.example", project, entity, StatusesBoth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []commitStatus{
+		{Context: "Aura", State: "pending", Description: "1 queued, 1 cancelled, 1 succeeded, 2 failed", TargetUrl: "http://aura.example/p/colors/commit/" + sha},
+		{Context: "Aura/deploy", State: "error", Description: "cancelled", TargetUrl: fmt.Sprintf("http://aura.example/j/%d", jobIds["deploy"])},
+		{Context: "Aura/docs", State: "pending", Description: "queued", TargetUrl: fmt.Sprintf("http://aura.example/j/%d", jobIds["docs"])},
+		{Context: "Aura/lint", State: "failure", Description: "failed with exit code 1", TargetUrl: fmt.Sprintf("http://aura.example/j/%d", jobIds["lint"])},
+		{Context: "Aura/test", State: "success", Description: "succeeded", TargetUrl: fmt.Sprintf("http://aura.example/j/%d", jobIds["test"])},
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %d statuses, got %+v", len(expected), statuses)
+	}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], statuses[i])
+		}
+	}
+
+	statuses, err = commitStatuses("http://aura.example", project, entity, StatusesJobs)
+	if err != nil || len(statuses) != 4 || statuses[0].Context != "Aura/deploy" {
+		t.Errorf("expected only job statuses, got %+v %v", statuses, err)
+	}
+	statuses, err = commitStatuses("http://aura.example", project, entity, "")
+	if err != nil || len(statuses) != 1 || statuses[0].Context != "Aura" {
+		t.Errorf("expected only the aggregate status, got %+v %v", statuses, err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// webhookClient is used for all outbound webhook deliveries so that a slow receiver cannot hold up the controller
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of the payload keyed with the secret
+func signWebhook(secret string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// generateWebhookSecret returns a random secret for webhooks created without one
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// notifyJobStatus sends the current status of the job to the webhooks of its project
+func notifyJobStatus(jobId int64) {
+	job, err := LoadJob(jobId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	event := "job." + jobStatus(job.Status)
+	entity, err := LoadEntity(job.EntityId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	webhooks, err := findSubscribedWebhooks(entity.ProjectId, event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	project, err := LoadProject(entity.ProjectId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	infos, err := jobInfos([]Job{job})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	description, state, err := getEntityStatus(entity.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	payload := api.WebhookPayload{
+		Event:       event,
+		Timestamp:   time.Now().Unix(),
+		Project:     api.ProjectInfo{Name: project.Name, Slug: project.Slug},
+		Entity:      entityInfo(entity),
+		Job:         &infos[0],
+		State:       state,
+		Description: description,
+		Url:         fmt.Sprintf("%s/j/%d", config.BaseUrl, job.Id),
+	}
+	deliverWebhooks(webhooks, payload)
+}
+
+// notifyEntityState sends the changed aggregated state of the entity to the webhooks of its project
+func notifyEntityState(project Project, entity EntityOrCollection, state string, description string) {
+	event := "entity." + state
+	webhooks, err := findSubscribedWebhooks(project.Id, event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	payload := api.WebhookPayload{
+		Event:       event,
+		Timestamp:   time.Now().Unix(),
+		Project:     api.ProjectInfo{Name: project.Name, Slug: project.Slug},
+		Entity:      entityInfo(entity),
+		State:       state,
+		Description: description,
+		Url:         fmt.Sprintf("%s/p/%s/%s/%s", config.BaseUrl, project.Slug, entity.Key, entity.Val),
+	}
+	deliverWebhooks(webhooks, payload)
+}
+
+func findSubscribedWebhooks(projectId int64, event string) ([]Webhook, error) {
+	webhooks, err := FindWebhooksByProjectId(projectId)
+	if err != nil {
+		return nil, err
+	}
+	results := []Webhook{}
+	for _, webhook := range webhooks {
+		if webhook.Wants(event) {
+			results = append(results, webhook)
+		}
+	}
+	return results, nil
+}
+
+func deliverWebhooks(webhooks []Webhook, payload api.WebhookPayload) {
+	if len(webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, webhook := range webhooks {
+		go deliverWebhook(webhook, payload.Event, body)
+	}
+}
+
+// deliverWebhook POSTs the body to the webhook and logs the delivery
+func deliverWebhook(webhook Webhook, event string, body []byte) {
+	t := time.Now()
+	statusCode := 0
+	errorMessage := ""
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewBuffer(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Aura/"+api.Version)
+		req.Header.Set(api.WebhookEventHeader, event)
+		req.Header.Set(api.WebhookSignatureHeader, "sha256="+signWebhook(webhook.Secret, body))
+		var resp *http.Response
+		resp, err = webhookClient.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			statusCode = resp.StatusCode
+			if statusCode < 200 || statusCode > 299 {
+				errorMessage = "got status " + resp.Status
+			}
+		}
+	}
+	if err != nil {
+		errorMessage = err.Error()
+	}
+	if errorMessage != "" {
+		log.Printf("webhook %d: %s: %s\n", webhook.Id, event, errorMessage)
+	}
+	err = CreateWebhookDelivery(webhook.Id, event, t, statusCode, errorMessage, time.Since(t))
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+func TestSignWebhook(t *testing.T) {
+	payload := []byte(`{"event":"job.failed"}`)
+	ok, err := validateWebhook("sha256="+signWebhook("secret", payload), "secret", payload)
+	if err != nil || !ok {
+		t.Fail()
+	}
+	ok, err = validateWebhook("sha256="+signWebhook("other", payload), "secret", payload)
+	if err != nil || ok {
+		t.Fail()
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	project := setupTestDatabase(t)
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan delivery, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		deliveries <- delivery{header: r.Header, body: body}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	_, err := CreateWebhook(project.Id, server.URL, "s3cret", []string{api.WebhookEventEntityFailure}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateWebhook(project.Id, server.URL, "other", []string{api.WebhookEventEntitySuccess}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks, err := findSubscribedWebhooks(project.Id, api.WebhookEventEntityFailure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 {
+		t.Fatalf("found %d subscribed webhooks, want 1", len(webhooks))
+	}
+	payload := api.WebhookPayload{
+		Event:   api.WebhookEventEntityFailure,
+		Project: api.ProjectInfo{Name: project.Name, Slug: project.Slug},
+		State:   "failure",
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliverWebhook(webhooks[0], payload.Event, body)
+
+	d := <-deliveries
+	if event := d.header.Get(api.WebhookEventHeader); event != payload.Event {
+		t.Errorf("event header is %q, want %q", event, payload.Event)
+	}
+	ok, err := validateWebhook(d.header.Get(api.WebhookSignatureHeader), "s3cret", d.body)
+	if err != nil || !ok {
+		t.Errorf("signature %q does not match the payload", d.header.Get(api.WebhookSignatureHeader))
+	}
+	var received api.WebhookPayload
+	err = json.Unmarshal(d.body, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Event != payload.Event || received.Project.Slug != "colors" || received.State != "failure" {
+		t.Errorf("received unexpected payload %+v", received)
+	}
+
+	stored, err := FindWebhookDeliveriesByProjectId(project.Id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("found %d stored deliveries, want 1", len(stored))
+	}
+	if stored[0].WebhookId != webhooks[0].Id || stored[0].Event != payload.Event || stored[0].StatusCode != http.StatusNoContent || stored[0].Error != "" {
+		t.Errorf("stored unexpected delivery %+v", stored[0])
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// restoreCache downloads the cache entry for the job and extracts it into the working directory.
+// Returns a message to include in the job log.
+// The client has to authenticate with the key of the job.
+func restoreCache(ctx context.Context, client *api.Client, job api.RunnerResponseJob, wd string) (string, error) {
+	content, key, err := client.CacheRestore(ctx, job.Id, job.Cache.Key, job.Cache.RestoreKeys)
+	if err != nil {
+		if api.IsStatus(err, http.StatusNotFound) {
+			return fmt.Sprintf("cache miss for key %s", job.Cache.Key), nil
+		}
+		return "", err
+	}
+	defer content.Close()
+	err = extractTarball(content, wd)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cache restored from key %s", key), nil
+}
+
+// saveCache packs the configured paths of the working directory and uploads them as cache entry for the job.
+// Returns a message to include in the job log.
+// The client has to authenticate with the key of the job.
+func saveCache(ctx context.Context, client *api.Client, job api.RunnerResponseJob, wd string) (string, error) {
+	// the tarball is streamed to the controller while it is created, as it can be too large to keep in memory
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(createTarball(pw, wd, job.Cache.Paths))
+	}()
+	err := client.CacheSave(ctx, job.Id, job.Cache.Key, pr)
+	pr.CloseWithError(err) // stops createTarball if the upload failed
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cache saved as key %s", job.Cache.Key), nil
+}
+
+func createTarball(w io.Writer, wd string, paths []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, p := range paths {
+		root := filepath.Join(wd, filepath.FromSlash(p))
+		err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && file == root { // nothing to cache for this path
+					return nil
+				}
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			link := ""
+			if info.Mode()&fs.ModeSymlink != 0 {
+				link, err = os.Readlink(file)
+				if err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(wd, file)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(name)
+			err = tw.WriteHeader(header)
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				f, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(tw, f)
+				f.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func extractTarball(r io.Reader, wd string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path %s in cache entry", header.Name)
+		}
+		target := filepath.Join(wd, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case tar.TypeReg:
+			err = extractFile(tr, target, fs.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			linkTarget := path.Clean(path.Join(path.Dir(name), filepath.ToSlash(header.Linkname)))
+			if filepath.IsAbs(header.Linkname) || linkTarget == ".." || strings.HasPrefix(linkTarget, "../") {
+				return fmt.Errorf("invalid link %s in cache entry", header.Name)
+			}
+			err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+			if err == nil {
+				err = os.Symlink(header.Linkname, target)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, perm fs.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,5 +1,5 @@
 module github.com/unnamedtiger/aura/runner-native
 
-go 1.19
+go 1.20
 
 require github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
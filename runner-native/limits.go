@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// effectiveLimits combines the limits of the runner with the limits requested by the job.
+// The stricter value wins, 0 means no limit.
+func effectiveLimits(runnerLimits api.JobLimits, jobLimits *api.JobLimits) api.JobLimits {
+	if jobLimits == nil {
+		return runnerLimits
+	}
+	limits := runnerLimits
+	if jobLimits.Cpus > 0 && (limits.Cpus == 0 || jobLimits.Cpus < limits.Cpus) {
+		limits.Cpus = jobLimits.Cpus
+	}
+	limits.Memory = minLimit(limits.Memory, jobLimits.Memory)
+	limits.Processes = minLimit(limits.Processes, jobLimits.Processes)
+	limits.Disk = minLimit(limits.Disk, jobLimits.Disk)
+	return limits
+}
+
+func minLimit(a int64, b int64) int64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// jobLimiter enforces the resource limits of a single job.
+// The platform-specific parts are implemented in limits_*.go.
+type jobLimiter struct {
+	jobId  int64
+	limits api.JobLimits
+	wd     string
+
+	// cgroupParent is the directory in which the cgroups for jobs are created (Linux only)
+	cgroupParent string
+
+	// cgroup is the directory of the cgroup the job runs in, empty if cgroups are not used
+	cgroup string
+
+	// cgroupDir is open until the process of the job started inside the cgroup (Linux only)
+	cgroupDir *os.File
+
+	// rlimits is set if the job runs with rlimits because cgroups could not be used (Linux only)
+	rlimits bool
+
+	mu           sync.Mutex
+	diskExceeded bool
+	done         chan struct{}
+}
+
+func newJobLimiter(cfg Config, jobId int64, wd string, limits api.JobLimits) *jobLimiter {
+	return &jobLimiter{jobId: jobId, limits: limits, wd: wd, cgroupParent: cfg.CgroupParent, done: make(chan struct{})}
+}
+
+// start begins enforcing the limits on the started command.
+// The command already runs with the CPU, memory and process limits set up by prepare.
+func (l *jobLimiter) start(cmd *exec.Cmd) {
+	l.releaseCgroupDir()
+	if l.limits.Disk > 0 {
+		go l.watchDisk(cmd)
+	}
+}
+
+// stop ends enforcing the limits once the command exited or failed to start.
+// Returns a message explaining which limit ended the job or an empty string.
+func (l *jobLimiter) stop(cmd *exec.Cmd) string {
+	l.releaseCgroupDir()
+	close(l.done)
+	l.mu.Lock()
+	diskExceeded := l.diskExceeded
+	l.mu.Unlock()
+	reason := l.reason(cmd.ProcessState)
+	l.cleanup()
+	if diskExceeded {
+		return "killed: disk limit exceeded"
+	}
+	return reason
+}
+
+func (l *jobLimiter) releaseCgroupDir() {
+	if l.cgroupDir != nil {
+		l.cgroupDir.Close()
+		l.cgroupDir = nil
+	}
+}
+
+func (l *jobLimiter) watchDisk(cmd *exec.Cmd) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if dirSize(l.wd) > l.limits.Disk {
+				l.mu.Lock()
+				l.diskExceeded = true
+				l.mu.Unlock()
+				l.kill(cmd)
+				return
+			}
+		}
+	}
+}
+
+func dirSize(dir string) int64 {
+	size := int64(0)
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // files may disappear while the job is running
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,218 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultCgroupParent = "/sys/fs/cgroup/aura"
+
+// rlimitNproc is RLIMIT_NPROC, it is not exported by package syscall
+const rlimitNproc = 6
+
+// rlimitCpuPeriod is the wall time a CPU limit is turned into CPU time for when falling back to rlimits
+const rlimitCpuPeriod = time.Hour
+
+// rlimitShimArg makes the runner set the rlimits given after it and then execute the job in its place
+const rlimitShimArg = "--aura-rlimit-shim"
+
+// prepare creates the cgroup of the job and lets the command start inside of it,
+// so that the limits apply before the job can fork or allocate memory.
+// Without cgroups the command is started through the rlimit shim instead.
+// Returns an error if the limits can not be applied, the job must not run then.
+func (l *jobLimiter) prepare(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if l.limits.Cpus == 0 && l.limits.Memory == 0 && l.limits.Processes == 0 {
+		return nil
+	}
+	err := l.createCgroup()
+	if err == nil {
+		l.cgroupDir, err = os.Open(l.cgroup)
+	}
+	if err != nil {
+		log.Printf("Unable to use cgroups, falling back to rlimits: %s", err)
+		l.cleanup()
+		l.cgroup = ""
+		return l.prepareRlimits(cmd)
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(l.cgroupDir.Fd())
+	return nil
+}
+
+// prepareRlimits lets the command start through the rlimit shim, which sets the rlimits before executing the job
+func (l *jobLimiter) prepareRlimits(cmd *exec.Cmd) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to apply limits: %w", err)
+	}
+	cpuTime := int64(l.limits.Cpus * rlimitCpuPeriod.Seconds())
+	args := []string{exe, rlimitShimArg, strconv.FormatInt(l.limits.Memory, 10), strconv.FormatInt(l.limits.Processes, 10), strconv.FormatInt(cpuTime, 10), cmd.Path}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = exe
+	l.rlimits = true
+	return nil
+}
+
+// runRlimitShim sets the rlimits and executes the job if the runner was started as rlimit shim, otherwise it returns
+func runRlimitShim() {
+	if len(os.Args) < 7 || os.Args[1] != rlimitShimArg {
+		return
+	}
+	err := setRlimits(os.Args[2], os.Args[3], os.Args[4])
+	if err == nil {
+		err = syscall.Exec(os.Args[5], os.Args[6:], os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "unable to apply limits: %s\n", err)
+	os.Exit(127)
+}
+
+func setRlimits(memory string, processes string, cpuTime string) error {
+	resources := []struct {
+		resource int
+		value    string
+		grace    uint64
+	}{
+		{syscall.RLIMIT_AS, memory, 0},
+		{rlimitNproc, processes, 0},
+		// the job gets SIGXCPU at the soft limit and is killed a few seconds later at the hard limit
+		{syscall.RLIMIT_CPU, cpuTime, 5},
+	}
+	for _, r := range resources {
+		limit, err := strconv.ParseUint(r.value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if limit == 0 {
+			continue
+		}
+		err = syscall.Setrlimit(r.resource, &syscall.Rlimit{Cur: limit, Max: limit + r.grace})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createCgroup creates the cgroup of the job with its limits and sets l.cgroup as soon as it exists
+func (l *jobLimiter) createCgroup() error {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	if err != nil {
+		return errors.New("cgroups v2 not available")
+	}
+	parent := l.cgroupParent
+	if parent == "" {
+		parent = defaultCgroupParent
+	}
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return err
+	}
+	err = writeCgroupFile(parent, "cgroup.subtree_control", "+cpu +memory +pids")
+	if err != nil {
+		return err
+	}
+	cgroup := filepath.Join(parent, fmt.Sprintf("job-%d", l.jobId))
+	err = os.Mkdir(cgroup, 0755)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	l.cgroup = cgroup
+	if l.limits.Cpus > 0 {
+		period := 100000
+		err = writeCgroupFile(cgroup, "cpu.max", fmt.Sprintf("%d %d", int(l.limits.Cpus*float64(period)), period))
+		if err != nil {
+			return err
+		}
+	}
+	if l.limits.Memory > 0 {
+		err = writeCgroupFile(cgroup, "memory.max", strconv.FormatInt(l.limits.Memory, 10))
+		if err != nil {
+			return err
+		}
+		// without swap the job gets killed instead of slowing down the whole machine
+		writeCgroupFile(cgroup, "memory.swap.max", "0")
+	}
+	if l.limits.Processes > 0 {
+		err = writeCgroupFile(cgroup, "pids.max", strconv.FormatInt(l.limits.Processes, 10))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kill terminates all processes of the job
+func (l *jobLimiter) kill(cmd *exec.Cmd) {
+	if l.cgroup != "" && writeCgroupFile(l.cgroup, "cgroup.kill", "1") == nil {
+		return
+	}
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func (l *jobLimiter) reason(state *os.ProcessState) string {
+	if l.rlimits {
+		// running out of memory or processes only makes allocations and forks of the job fail, which can not be told apart
+		if state == nil {
+			return ""
+		}
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGXCPU {
+			return "killed: CPU time limit exceeded"
+		}
+		return ""
+	}
+	if l.cgroup == "" {
+		return ""
+	}
+	if readCgroupEvent(l.cgroup, "memory.events", "oom_kill") > 0 {
+		return "killed: memory limit exceeded"
+	}
+	if readCgroupEvent(l.cgroup, "pids.events", "max") > 0 {
+		return "killed: process limit reached"
+	}
+	return ""
+}
+
+func (l *jobLimiter) cleanup() {
+	if l.cgroup == "" {
+		return
+	}
+	writeCgroupFile(l.cgroup, "cgroup.kill", "1") // left-over background processes
+	for i := 0; i < 10; i++ {
+		err := os.Remove(l.cgroup)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("Unable to remove cgroup %s", l.cgroup)
+}
+
+func writeCgroupFile(cgroup string, name string, content string) error {
+	return os.WriteFile(filepath.Join(cgroup, name), []byte(content), 0644)
+}
+
+func readCgroupEvent(cgroup string, name string, event string) int64 {
+	data, err := os.ReadFile(filepath.Join(cgroup, name))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, found := strings.Cut(line, " ")
+		if found && k == event {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// runRlimitShim is only used on Linux
+func runRlimitShim() {
+}
+
+// prepare returns an error if the job has limits that can not be applied, the job must not run then
+func (l *jobLimiter) prepare(cmd *exec.Cmd) error {
+	if l.limits.Cpus > 0 || l.limits.Memory > 0 || l.limits.Processes > 0 {
+		return errors.New("unable to apply limits, CPU, memory and process limits are only supported on Linux")
+	}
+	return nil
+}
+
+func (l *jobLimiter) kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func (l *jobLimiter) reason(state *os.ProcessState) string {
+	return ""
+}
+
+func (l *jobLimiter) cleanup() {
+}
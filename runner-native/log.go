@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// logRecorder collects the output of a job line by line with timestamp and stream
+type logRecorder struct {
+	mu      sync.Mutex
+	lines   []api.LogLine
+	partial map[string][]byte
+}
+
+func newLogRecorder() *logRecorder {
+	return &logRecorder{partial: map[string][]byte{}}
+}
+
+// writer returns an io.Writer that records everything written to it as lines of the stream
+func (r *logRecorder) writer(stream string) io.Writer {
+	return logStreamWriter{r, stream}
+}
+
+type logStreamWriter struct {
+	r      *logRecorder
+	stream string
+}
+
+func (w logStreamWriter) Write(p []byte) (int, error) {
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+	t := time.Now().UnixMilli()
+	buf := append(w.r.partial[w.stream], p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.r.append(t, w.stream, string(buf[:i]))
+		buf = buf[i+1:]
+	}
+	w.r.partial[w.stream] = append([]byte{}, buf...)
+	return len(p), nil
+}
+
+// message records a message of the runner itself
+func (r *logRecorder) message(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.append(time.Now().UnixMilli(), api.StreamAura, msg)
+}
+
+func (r *logRecorder) append(t int64, stream string, line string) {
+	r.lines = append(r.lines, api.LogLine{Time: t, Stream: stream, Line: strings.TrimSuffix(line, "\r")})
+}
+
+// flush records the remaining output of every stream that did not end with a line break
+func (r *logRecorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := time.Now().UnixMilli()
+	for _, stream := range []string{api.StreamStdout, api.StreamStderr} {
+		if len(r.partial[stream]) > 0 {
+			r.append(t, stream, string(r.partial[stream]))
+			delete(r.partial, stream)
+		}
+	}
+}
+
+// text returns the plain text log
+func (r *logRecorder) text() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	for _, line := range r.lines {
+		if line.Stream == api.StreamAura {
+			buf.WriteString("aura: ")
+		}
+		buf.WriteString(line.Line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// structured returns the structured log with one api.LogLine per line
+func (r *logRecorder) structured() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, line := range r.lines {
+		err := enc.Encode(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
@@ -22,10 +22,21 @@ type Config struct {
 	Controller string   `json:"controller"`
 	RunnerKey  string   `json:"runnerKey"`
 	Tags       []string `json:"tags"`
+
+	// Used to obtain a runnerKey on first start if none is configured
+	RegistrationKey string `json:"registrationKey"`
+
+	// Limits applied to every job, jobs may only restrict them further
+	Limits api.JobLimits `json:"limits"`
+
+	// Directory in which cgroups for jobs are created (Linux only)
+	CgroupParent string `json:"cgroupParent"`
 }
 
 func main() {
-	data, err := os.ReadFile("config.json")
+	runRlimitShim()
+	configFile := "config.json"
+	data, err := os.ReadFile(configFile)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -34,13 +45,20 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	registering := len(cfg.RunnerKey) == 0 && len(cfg.RegistrationKey) > 0
+	if len(cfg.Name) == 0 && registering {
+		cfg.Name, err = os.Hostname()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 	if len(cfg.Name) == 0 {
 		log.Fatalln("invalid name")
 	}
 	if len(cfg.Controller) == 0 {
 		log.Fatalln("invalid controller")
 	}
-	if len(cfg.RunnerKey) == 0 {
+	if len(cfg.RunnerKey) == 0 && !registering {
 		log.Fatalln("invalid runnerKey")
 	}
 	if len(cfg.Tags) == 0 {
@@ -50,39 +68,29 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	auraApi := api.New(controllerUrl)
-	log.Printf("Starting runner %s...", cfg.Name)
-
-	for {
-		req := api.RunnerRequest{Name: cfg.Name, Tags: cfg.Tags, Limit: 1}
-		reqData, err := json.Marshal(req)
+	client := api.NewClient(controllerUrl, cfg.RunnerKey)
+	if registering {
+		log.Printf("Registering runner %s...", cfg.Name)
+		err = register(&cfg, client.WithKey(cfg.RegistrationKey), configFile)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		httpReq, err := http.NewRequest(http.MethodPost, auraApi.Runner(), bytes.NewBuffer(reqData))
-		if err != nil {
-			log.Fatalln(err)
-		}
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Authorization", "Bearer "+cfg.RunnerKey)
-		respObj, err := http.DefaultClient.Do(httpReq)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		if respObj.StatusCode != http.StatusOK {
-			log.Fatalln("got status " + respObj.Status)
-		}
-		var resp api.RunnerResponse
-		err = json.NewDecoder(respObj.Body).Decode(&resp)
+		client = client.WithKey(cfg.RunnerKey)
+	}
+	log.Printf("Starting runner %s...", cfg.Name)
+
+	ctx := context.Background()
+	for {
+		req := api.RunnerRequest{Name: cfg.Name, Version: api.Version, Protocol: api.ProtocolLevel, Tags: cfg.Tags, Limit: 1, Info: systemInfo()}
+		resp, err := client.RequestJobs(ctx, req)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		respObj.Body.Close()
 
 		if len(resp.Jobs) > 0 {
 			for _, job := range resp.Jobs {
 				log.Printf("Running job %d...", job.Id)
-				runJob(cfg, auraApi, job)
+				runJob(ctx, cfg, client, job)
 			}
 		} else {
 			log.Println("Sleeping...")
@@ -91,9 +99,10 @@ func main() {
 	}
 }
 
-func runJob(cfg Config, auraApi *api.AuraApi, job api.RunnerResponseJob) {
+func runJob(ctx context.Context, cfg Config, client *api.Client, job api.RunnerResponseJob) {
 	exitCode := 0
-	out := []byte{}
+	message := ""
+	recorder := newLogRecorder()
 	parts, err := shlex.Split(job.Cmd)
 	if err != nil {
 		log.Println(err)
@@ -114,6 +123,7 @@ func runJob(cfg Config, auraApi *api.AuraApi, job api.RunnerResponseJob) {
 			env := []string{}
 			env = append(env, "CI=true")
 			env = append(env, "AURA_CI=true")
+			env = append(env, fmt.Sprintf("AURA_CONTROLLER=%s", cfg.Controller))
 			env = append(env, fmt.Sprintf("AURA_JOBID=%d", job.Id))
 			env = append(env, fmt.Sprintf("AURA_JOBNAME=%s", job.Name))
 			env = append(env, fmt.Sprintf("AURA_JOBKEY=%s", job.JobKey))
@@ -122,7 +132,29 @@ func runJob(cfg Config, auraApi *api.AuraApi, job api.RunnerResponseJob) {
 			env = append(env, fmt.Sprintf("AURA_ENTITYVAL=%s", job.EntityVal))
 			env = append(env, strings.Split(job.Env, "\n")...)
 			cmd.Env = env
-			out, err = cmd.CombinedOutput()
+			if job.Cache != nil {
+				msg, err := restoreCache(ctx, client.WithKey(job.JobKey), job, wd)
+				if err != nil {
+					log.Println(err)
+					msg = fmt.Sprintf("unable to restore cache: %s", err)
+				}
+				recorder.message(msg)
+			}
+			cmd.Stdout = recorder.writer(api.StreamStdout)
+			cmd.Stderr = recorder.writer(api.StreamStderr)
+			limiter := newJobLimiter(cfg, job.Id, wd, effectiveLimits(cfg.Limits, job.Limits))
+			err = limiter.prepare(cmd)
+			if err != nil {
+				message = err.Error()
+			} else {
+				err = cmd.Start()
+				if err == nil {
+					limiter.start(cmd)
+					err = cmd.Wait()
+				}
+				message = limiter.stop(cmd)
+			}
+			recorder.flush()
 			if err != nil {
 				exitError, ok := err.(*exec.ExitError)
 				if ok {
@@ -132,7 +164,18 @@ func runJob(cfg Config, auraApi *api.AuraApi, job api.RunnerResponseJob) {
 					exitCode = -1
 				}
 			}
-			log.Println(string(out))
+			if message != "" {
+				recorder.message(message)
+			}
+			if job.Cache != nil && exitCode == 0 {
+				msg, err := saveCache(ctx, client.WithKey(job.JobKey), job, wd)
+				if err != nil {
+					log.Println(err)
+					msg = fmt.Sprintf("unable to save cache: %s", err)
+				}
+				recorder.message(msg)
+			}
+			log.Println(string(recorder.text()))
 			err = os.RemoveAll(wd)
 			if err != nil {
 				log.Println(err)
@@ -141,36 +184,25 @@ func runJob(cfg Config, auraApi *api.AuraApi, job api.RunnerResponseJob) {
 		}
 	}
 
-	req := api.JobRequest{Name: cfg.Name, Id: job.Id, ExitCode: int64(exitCode)}
-	reqData, err := json.Marshal(req)
+	req := api.JobRequest{Name: cfg.Name, Id: job.Id, ExitCode: int64(exitCode), Message: message}
+	_, err = client.CompleteJob(ctx, req)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	completeJobReq, err := http.NewRequest(http.MethodPost, auraApi.Job(), bytes.NewBuffer(reqData))
-	if err != nil {
-		log.Fatalln(err)
-	}
-	completeJobReq.Header.Set("Content-Type", "application/json")
-	completeJobReq.Header.Set("Authorization", "Bearer "+cfg.RunnerKey)
-	completeJobResp, err := http.DefaultClient.Do(completeJobReq)
+
+	// the job is completed already, a missing log must not take down the runner
+	err = client.UploadLog(ctx, job.Id, bytes.NewReader(recorder.text()))
 	if err != nil {
-		log.Fatalln(err)
-	}
-	if completeJobResp.StatusCode != http.StatusOK {
-		log.Fatalln("got status " + completeJobResp.Status)
+		log.Printf("Unable to upload log of job %d: %s", job.Id, err)
 	}
 
-	logUploadReq, err := http.NewRequest(http.MethodPost, auraApi.Storage(job.Id, "log"), bytes.NewBuffer(out))
+	structuredLog, err := recorder.structured()
 	if err != nil {
-		log.Fatalln(err)
+		log.Printf("Unable to encode structured log of job %d: %s", job.Id, err)
+		return
 	}
-	logUploadReq.Header.Set("Content-Type", "application/json")
-	logUploadReq.Header.Set("Authorization", "Bearer "+cfg.RunnerKey)
-	logUploadResp, err := http.DefaultClient.Do(logUploadReq)
+	err = client.UploadStructuredLog(ctx, job.Id, bytes.NewReader(structuredLog))
 	if err != nil {
-		log.Fatalln(err)
-	}
-	if logUploadResp.StatusCode != http.StatusOK {
-		log.Fatalln("got status " + logUploadResp.Status)
+		log.Printf("Unable to upload structured log of job %d: %s", job.Id, err)
 	}
 }
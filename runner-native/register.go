@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// register exchanges the registration key for a runner key and stores it in the config file
+// The client has to authenticate with the registration key.
+func register(cfg *Config, client *api.Client, configFile string) error {
+	resp, err := client.Register(context.Background(), api.RegisterRequest{Name: cfg.Name})
+	if err != nil {
+		return err
+	}
+	cfg.RunnerKey = resp.RunnerKey
+	cfg.RegistrationKey = ""
+	return saveRegistration(configFile, cfg.Name, cfg.RunnerKey)
+}
+
+// saveRegistration writes name and runner key into the config file and removes the registration key.
+// All other settings are kept as they are.
+func saveRegistration(configFile string, name string, runnerKey string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return err
+	}
+	nameData, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	runnerKeyData, err := json.Marshal(runnerKey)
+	if err != nil {
+		return err
+	}
+	values["name"] = nameData
+	values["runnerKey"] = runnerKeyData
+	delete(values, "registrationKey")
+	data, err = json.MarshalIndent(values, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, append(data, '\n'), 0600)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/unnamedtiger/aura/api"
+)
+
+// systemInfo collects the information about this runner that is reported to the controller
+func systemInfo() *api.RunnerInfo {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Println(err)
+	}
+	return &api.RunnerInfo{
+		Os:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Cpus:     runtime.NumCPU(),
+		Memory:   totalMemory(),
+		FreeDisk: freeDisk("w"),
+		Hostname: hostname,
+	}
+}
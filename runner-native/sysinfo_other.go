@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+func totalMemory() int64 {
+	return 0
+}
+
+func freeDisk(dir string) int64 {
+	return 0
+}